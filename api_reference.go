@@ -3,6 +3,7 @@ package sq
 import (
 	"context"
 	"database/sql"
+	"time"
 )
 
 type APIDatabase interface {
@@ -10,6 +11,20 @@ type APIDatabase interface {
 	Ping() error
 	// 配置 SQL 验证器
 	SetSQLChecker(sqlChecker SQLChecker)
+	// 配置 SQL 方言
+	SetDialect(dialect Dialect)
+	// 配置批量插入时单条 SQL 的最大字节数
+	SetMaxAllowedPacket(bytes int)
+	// 注册拦截器(日志 监控 链路追踪等)
+	Use(interceptors ...Interceptor)
+	// 配置结构化日志
+	SetLogger(logger Logger)
+	// 配置慢查询阈值, 超过阈值时自动 EXPLAIN
+	SetSlowQuery(threshold time.Duration, handle func(ctx context.Context, event SlowQueryEvent))
+	// 配置事务 handle panic 时回滚后返回 *TxPanicError 而不是重新 panic
+	SetTxPanicAsError(asError bool)
+	// 跨作用域查询时跳过 ScopeTabler 的作用域, 会记录日志
+	WithoutScopes(ctx context.Context, reason string) context.Context
 	// 关闭数据库连接
 	Close() error
 
@@ -17,6 +32,10 @@ type APIDatabase interface {
 	Insert(ctx context.Context, qb QB) (result sql.Result, err error)
 	// 基于 Model 创建数据
 	InsertModel(ctx context.Context, ptr Model, checkSQL ...string) (err error)
+	// 基于 Model 插入或更新数据 INSERT INTO ... ON DUPLICATE KEY UPDATE
+	UpsertModel(ctx context.Context, ptr Model, updateColumns []Column, checkSQL ...string) (result sql.Result, err error)
+	// 基于 Model slice 批量创建数据，会根据占位符数量和 max_allowed_packet 自动拆分为多条 SQL
+	InsertModels(ctx context.Context, slicePtr interface{}, checkSQL ...string) (err error)
 
 	// 查询单行多列 类似 sql.Row{}.Scan()
 	QueryRowScan(ctx context.Context, qb QB, desc ...interface{}) (has bool, err error)
@@ -26,6 +45,10 @@ type APIDatabase interface {
 	QuerySlice(ctx context.Context, slicePtr interface{}, qb QB) (err error)
 	// 查询多行多列(自定义扫描)
 	QuerySliceScaner(ctx context.Context, qb QB, scaner Scaner) (err error)
+	// 逐行读取查询结果(大数据量导出)
+	QueryIterator(ctx context.Context, qb QB, elemPtr Tabler) (iter *Iterator, err error)
+	// 基于 qb.OrderBy 的 keyset 分页
+	QueryKeyset(ctx context.Context, slicePtr interface{}, qb QB, cursor string, perPage int) (page KeysetPage, err error)
 
 	QueryRelation(ctx context.Context, ptr Relation, qb QB) (has bool, err error)
 	// 查询多条数据并转换为 Relation slice
@@ -33,6 +56,8 @@ type APIDatabase interface {
 
 	// count
 	Count(ctx context.Context, qb QB) (count uint64, err error)
+	// 查询总数和分页数据
+	QueryPage(ctx context.Context, slicePtr interface{}, qb QB, page int, perPage int) (result Page, err error)
 	// 查询数据是否存在(单条数据是否存在不建议使用 count 而是使用 Exist)
 	Has(ctx context.Context, qb QB) (has bool, err error)
 	// sum
@@ -43,6 +68,13 @@ type APIDatabase interface {
 	Update(ctx context.Context, qb QB) (result sql.Result, err error)
 	// 基于 Model 更新数据
 	UpdateModel(ctx context.Context, ptr Model, updateData []Update, where []Condition, checkSQL ...string) (result sql.Result, err error)
+	// 基于 Model 快照只更新发生变化的字段, Model 需要组合 sq.Tracker
+	SaveModel(ctx context.Context, ptr Trackable, checkSQL ...string) (result sql.Result, err error)
+	// 基于 Model 原子递增递减 SET `column` = `column` + ?, 不满足边界条件时 affected 为 false
+	IncrementIntModel(ctx context.Context, ptr Model, props IncrementInt, checkSQL ...string) (affected bool, err error)
+	IncrementFloatModel(ctx context.Context, ptr Model, props IncrementFloat, checkSQL ...string) (affected bool, err error)
+	DecrementIntModel(ctx context.Context, ptr Model, props DecrementInt, checkSQL ...string) (affected bool, err error)
+	DecrementFloatModel(ctx context.Context, ptr Model, props DecrementFloat, checkSQL ...string) (affected bool, err error)
 
 	// 删除测试数据库的数据，只能运行在 test_ 为前缀的数据库中
 	ClearTestData(ctx context.Context, qb QB) (result sql.Result, err error)
@@ -58,15 +90,25 @@ type APIDatabase interface {
 	// 基于 Model 软删除（可恢复）
 	SoftDeleteModel(ctx context.Context, ptr Model, checkSQL ...string) (result sql.Result, err error)
 
+	// 恢复软删除的数据, 通过 QB.SoftDeleteMode = sq.OnlyTrashed 可以查询已删除的数据
+	Restore(ctx context.Context, qb QB) (result sql.Result, err error)
+	// 基于 Model 恢复软删除的数据
+	RestoreModel(ctx context.Context, ptr Model, checkSQL ...string) (result sql.Result, err error)
+
 	// 执行QB
 	ExecQB(ctx context.Context, qb QB, statement Statement) (result sql.Result, err error)
 	// 执行
 	Exec(ctx context.Context, query string, values []interface{}) (result sql.Result, err error)
 
+	// 返回 sq.WithTx(ctx, tx) 放入 context 的事务, 没有事务时返回 db
+	From(ctx context.Context) Executor
+
 	// 开启事务
 	Transaction(ctx context.Context, handle func (tx *Transaction) TxResult) (isRollback bool, err error)
 	// 开启自定义级别的事务
 	TransactionOpts(ctx context.Context, handle func (tx *Transaction) TxResult, opts *sql.TxOptions) (isRollback bool, err error)
+	// 开启事务, 死锁或锁等待超时时回滚并重新执行
+	TransactionRetry(ctx context.Context, handle func (tx *Transaction) TxResult, opts *sql.TxOptions, policy RetryPolicy) (isRollback bool, attempts int, err error)
 }
 func verifyDoc() {
 	db := &Database{}
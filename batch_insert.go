@@ -0,0 +1,105 @@
+package sq
+
+import (
+	"database/sql"
+	"time"
+)
+
+// MySQL 5.7 max_allowed_packet 的默认值，可通过 Database.SetMaxAllowedPacket 修改
+const DefaultMaxAllowedPacket = 4 << 20
+
+// 将 InsertMultiple 拆分为多组，保证每组的占位符数量不超过 maxPlaceholders 且预估的 SQL 大小不超过 maxAllowedPacket
+func (multiple InsertMultiple) chunk(maxPlaceholders int, maxAllowedPacket int) (chunks []InsertMultiple) {
+	columnCount := len(multiple.Column)
+	if columnCount == 0 {
+		return nil
+	}
+	if maxAllowedPacket <= 0 {
+		maxAllowedPacket = DefaultMaxAllowedPacket
+	}
+	// INSERT INTO `table` (`a`,`b`) VALUES
+	baseSize := 64
+	for _, column := range multiple.Column {
+		baseSize += len(column) + 3
+	}
+	current := InsertMultiple{Column: multiple.Column}
+	currentSize := baseSize
+	for _, row := range multiple.Values {
+		rowSize := 2*columnCount + 2
+		for _, value := range row {
+			rowSize += estimateValueSize(value)
+		}
+		rowCount := len(current.Values)
+		overPlaceholders := (rowCount+1)*columnCount > maxPlaceholders
+		overPacket := currentSize+rowSize > maxAllowedPacket
+		if rowCount != 0 && (overPlaceholders || overPacket) {
+			chunks = append(chunks, current)
+			current = InsertMultiple{Column: multiple.Column}
+			currentSize = baseSize
+		}
+		current.Values = append(current.Values, row)
+		currentSize += rowSize
+	}
+	if len(current.Values) != 0 {
+		chunks = append(chunks, current)
+	}
+	return
+}
+// ON DUPLICATE KEY UPDATE ON CONFLICT DO UPDATE 中的占位符数量
+func (qb QB) conflictPlaceholders() (count int, err error) {
+	if len(qb.OnDuplicateKeyUpdate) == 0 {
+		return
+	}
+	qb, err = qb.resolve(Statement("").Enum().Insert) ; if err != nil {
+		return
+	}
+	dialect := coalesceDialect(qb.Dialect)
+	updates, err := qb.scopeConflictUpdates(dialect) ; if err != nil {
+		return
+	}
+	return len(updateSetsSQL(updates, dialect).Values), nil
+}
+// 预估单个值在请求包中占用的字节数
+func estimateValueSize(value interface{}) int {
+	switch v := value.(type) {
+	case string:
+		return len(v) + 9
+	case []byte:
+		return len(v) + 9
+	case time.Time:
+		return 12
+	default:
+		return 9
+	}
+}
+
+// 批量插入被拆分为多条 SQL 时合并多个 sql.Result
+// LastInsertId 与 MySQL 一致，返回第一条数据的自增id, RowsAffected 为所有 SQL 影响行数之和
+type batchResult []sql.Result
+func (results batchResult) LastInsertId() (id int64, err error) {
+	if len(results) == 0 {
+		return 0, nil
+	}
+	return results[0].LastInsertId()
+}
+func (results batchResult) RowsAffected() (affected int64, err error) {
+	for _, result := range results {
+		var rowsAffected int64
+		rowsAffected, err = result.RowsAffected() ; if err != nil {
+			return
+		}
+		affected += rowsAffected
+	}
+	return
+}
+// 通过 RETURNING 获取自增id时的 sql.Result, LastInsertId 返回第一条数据的自增id
+type returningResult []int64
+func (ids returningResult) LastInsertId() (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return ids[0], nil
+}
+func (ids returningResult) RowsAffected() (int64, error) {
+	return int64(len(ids)), nil
+}
@@ -13,9 +13,26 @@ import (
 type Database struct {
 	Core *sqlx.DB
 	sqlChecker SQLChecker
+	dialect Dialect
+	maxAllowedPacket int
+	interceptors []Interceptor
+	logger Logger
+	slowQuery *slowQuery
+	replicas *replicaSet
+	txPanicAsError bool
 }
 func (db *Database) Ping() error {
-	return db.Core.Ping()
+	err := db.Core.Ping() ; if err != nil {
+		return err
+	}
+	if db.replicas != nil {
+		for _, core := range db.replicas.cores {
+			err = core.Ping() ; if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 func (db *Database) getCore() (core StoragerCore) {
 	return db.Core
@@ -26,12 +43,40 @@ func (db *Database) getSQLChecker() (sqlChecker SQLChecker) {
 func (db *Database) SetSQLChecker(sqlChecker SQLChecker) {
 	db.sqlChecker = sqlChecker
 }
+func (db *Database) getDialect() (dialect Dialect) {
+	return db.dialect
+}
+// Open 会根据 driverName 自动选择 Dialect, 使用自定义 driverName 时可通过 SetDialect 配置
+func (db *Database) SetDialect(dialect Dialect) {
+	db.dialect = dialect
+}
+func (db *Database) getInterceptors() []Interceptor {
+	return db.interceptors
+}
+func (db *Database) getLogger() Logger {
+	return db.logger
+}
+func (db *Database) getSlowQuery() *slowQuery {
+	return db.slowQuery
+}
+func (db *Database) getTxID() string {
+	return ""
+}
+func (db *Database) getMaxAllowedPacket() int {
+	return db.maxAllowedPacket
+}
+// 批量插入时单条 SQL 的最大字节数，应与数据库的 max_allowed_packet 保持一致，默认 DefaultMaxAllowedPacket
+func (db *Database) SetMaxAllowedPacket(bytes int) {
+	db.maxAllowedPacket = bytes
+}
 func Open(driverName string, dataSourceName string) (db *Database, dbClose func() error, err error) {
 	var coreDatabase *sqlx.DB
 	coreDatabase, err = sqlx.Open(driverName, dataSourceName)
 	db = &Database{
 		Core: coreDatabase,
 		sqlChecker: &defaultSQLCheck{},
+		dialect: DialectByDriverName(driverName),
+		maxAllowedPacket: DefaultMaxAllowedPacket,
 	}
 	if err != nil && coreDatabase != nil {
 		dbClose = coreDatabase.Close
@@ -43,9 +88,20 @@ func Open(driverName string, dataSourceName string) (db *Database, dbClose func(
 	}
 	return
 }
-func (db *Database) Close() error {
+// 关闭所有从库和主库, 某个连接关闭失败时依然会关闭其他连接, 返回第一个错误
+func (db *Database) Close() (err error) {
 	if db.Core != nil {
-		return db.Core.Close()
+		if db.replicas != nil {
+			for _, core := range db.replicas.cores {
+				closeErr := core.Close() ; if closeErr != nil && err == nil {
+					err = closeErr
+				}
+			}
+		}
+		closeErr := db.Core.Close() ; if closeErr != nil && err == nil {
+			err = closeErr
+		}
+		return
 	}
 	log.Print("Database is nil,maybe you forget sq.Open()")
 	return nil
@@ -61,7 +117,27 @@ func (tx *Transaction) Insert(ctx context.Context, qb QB) (result sql.Result, er
 }
 func coreInsert(ctx context.Context, storager Storager, qb QB) (result sql.Result, err error) {
 	qb.SQLChecker = storager.getSQLChecker()
-	return coreExecQB(ctx, storager, qb, Statement("").Enum().Insert)
+	qb.Dialect = storager.getDialect()
+	qb.scopeCtx = ctx
+	if len(qb.InsertMultiple.Column) == 0 {
+		return coreExecQB(ctx, storager, qb, Statement("").Enum().Insert)
+	}
+	// 批量插入拆分为多条 SQL 执行，需要保证原子性时应在事务中执行
+	var results batchResult
+	// ON DUPLICATE KEY UPDATE 中的占位符在每条 SQL 中都会出现, 需要从 MaxPlaceholders 中扣除
+	conflictPlaceholders, err := qb.conflictPlaceholders() ; if err != nil {
+		return
+	}
+	chunks := qb.InsertMultiple.chunk(coalesceDialect(qb.Dialect).MaxPlaceholders() - conflictPlaceholders, storager.getMaxAllowedPacket())
+	for _, chunk := range chunks {
+		chunkQB := qb
+		chunkQB.InsertMultiple = chunk
+		result, err = coreExecQB(ctx, storager, chunkQB, Statement("").Enum().Insert) ; if err != nil {
+			return
+		}
+		results = append(results, result)
+	}
+	return results, nil
 }
 
 func (db *Database) InsertModel(ctx context.Context, ptr Model, checkSQL ...string) (err error) {
@@ -72,25 +148,220 @@ func (tx *Transaction) InsertModel(ctx context.Context, ptr Model, checkSQL ...s
 }
 
 func coreInsertModel(ctx context.Context, storager Storager, ptr Model, checkSQL ...string) (err error) {
-	err = ptr.BeforeCreate() ; if err != nil {return}
 	qb := QB{
 		Table: ptr,
 	}
 	qb.CheckSQL = checkSQL
 	qb.SQLChecker = storager.getSQLChecker()
+	qb.Dialect = storager.getDialect()
+	qb.scopeCtx = ctx
+	data, err := modelInsertData(ctx, ptr) ; if err != nil {
+		return
+	}
+	for i, column := range data.columns {
+		qb.Insert = append(qb.Insert, Insert{Column: column, Value: data.values[i]})
+	}
+	result, err := execInsertModels(ctx, storager, qb, []modelInsert{data}) ; if err != nil {
+		return
+	}
+	err = ptr.AfterCreate(result) ; if err != nil {
+		return
+	}
+	return
+}
+type modelInsert struct {
+	columns []Column
+	values []interface{}
+	// `sq:"autoincr"` 字段, 值为零时不会插入而是在插入后回填
+	autoIncrementColumn Column
+	autoIncrementValue reflect.Value
+}
+// 执行 BeforeCreate ColumnBeforeCreate 并通过 eachField 获取需要插入的字段和值
+func modelInsertData(ctx context.Context, ptr Model) (data modelInsert, err error) {
 	rValue := reflect.ValueOf(ptr)
 	rType := rValue.Type()
 	if rType.Kind() != reflect.Ptr {
 		panic(errors.New("InsertModel(ctx, ptr) " + rType.String() + " must be ptr"))
 	}
-	elemValue := rValue.Elem()
-	elemType := rType.Elem()
-	eachField(elemValue, elemType, func(column string, fieldType reflect.StructField, fieldValue reflect.Value) {
-		qb.Insert = append(qb.Insert, Insert{Column: Column(column), Value: fieldValue.Interface()})
+	err = ptr.BeforeCreate() ; if err != nil {
+		return
+	}
+	if columnBeforeCreate, ok := ptr.(ColumnBeforeCreate); ok {
+		columnBeforeCreate.ColumnBeforeCreate()
+	}
+	err = stampScopes(ctx, ptr) ; if err != nil {
+		return
+	}
+	eachField(rValue.Elem(), rType.Elem(), func(column string, fieldType reflect.StructField, fieldValue reflect.Value) {
+		if (Tag{fieldType.Tag.Get("sq")}).IsAutoIncrement() && fieldValue.IsZero() {
+			data.autoIncrementColumn = Column(column)
+			data.autoIncrementValue = fieldValue
+			return
+		}
+		data.columns = append(data.columns, Column(column))
+		data.values = append(data.values, fieldValue.Interface())
 	})
-	raw := qb.SQLInsert()
-	query, values := raw.Query, raw.Values
-	result, err := storager.getCore().ExecContext(ctx, query, values...) ; if err != nil {
+	return
+}
+// 执行一条插入 SQL, 并将自增id回填到 `sq:"autoincr"` 字段
+// 支持 RETURNING 的数据库使用 RETURNING 获取自增id, 否则使用 LastInsertId(批量插入时是第一行的id) 推算每一行的id
+// MySQL 的多行 INSERT VALUES 在任意 innodb_autoinc_lock_mode 下分配的id都是连续的, 间隔为 @@auto_increment_increment
+// 影响行数与插入行数不一致或无法确定间隔时不会回填, `sq:"autoincr"` 字段保持零值
+func execInsertModels(ctx context.Context, storager Storager, qb QB, models []modelInsert) (result sql.Result, err error) {
+	autoIncrementColumn := models[0].autoIncrementColumn
+	if autoIncrementColumn == "" {
+		var raw Raw
+		raw, err = qb.build(Statement("").Enum().Insert) ; if err != nil {
+			return
+		}
+		return execContext(ctx, storager, raw.Query, raw.Values)
+	}
+	if coalesceDialect(qb.Dialect).SupportReturning() {
+		qb.Returning = []Column{autoIncrementColumn}
+		var raw Raw
+		raw, err = qb.build(Statement("").Enum().Insert) ; if err != nil {
+			return
+		}
+		var rows *sqlx.Rows
+		rows, err = queryxContext(ctx, storager, raw.Query, raw.Values) ; if err != nil {
+			return
+		}
+		defer func() {
+			closeErr := rows.Close() ; if closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}()
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			err = rows.Scan(&id) ; if err != nil {
+				return
+			}
+			ids = append(ids, id)
+		}
+		err = rows.Err() ; if err != nil {
+			return
+		}
+		if len(ids) != len(models) {
+			return nil, errors.New("goclub/sql: RETURNING " + autoIncrementColumn.String() + " rows length not equal insert rows length")
+		}
+		for i, model := range models {
+			setAutoIncrement(model.autoIncrementValue, ids[i])
+		}
+		return returningResult(ids), nil
+	}
+	raw, err := qb.build(Statement("").Enum().Insert) ; if err != nil {
+		return
+	}
+	result, err = execContext(ctx, storager, raw.Query, raw.Values) ; if err != nil {
+		return
+	}
+	firstID, err := result.LastInsertId() ; if err != nil {
+		return
+	}
+	if len(models) == 1 {
+		setAutoIncrement(models[0].autoIncrementValue, firstID)
+		return
+	}
+	if _, isMySQL := coalesceDialect(qb.Dialect).(MySQLDialect); !isMySQL {
+		return
+	}
+	rowsAffected, err := result.RowsAffected() ; if err != nil {
+		return
+	}
+	if rowsAffected != int64(len(models)) {
+		return
+	}
+	var increment int64
+	err = storager.getCore().QueryRowxContext(ctx, "SELECT @@auto_increment_increment").Scan(&increment) ; if err != nil {
+		return
+	}
+	if increment <= 0 {
+		return
+	}
+	for i, model := range models {
+		setAutoIncrement(model.autoIncrementValue, firstID + int64(i) * increment)
+	}
+	return
+}
+func setAutoIncrement(fieldValue reflect.Value, id int64) {
+	switch fieldValue.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		fieldValue.SetInt(id)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		fieldValue.SetUint(uint64(id))
+	default:
+		panic(errors.New("goclub/sql: `sq:\"autoincr\"` field must be int or uint, can not be " + fieldValue.Type().String()))
+	}
+}
+func (db *Database) UpsertModel(ctx context.Context, ptr Model, updateColumns []Column, checkSQL ...string) (result sql.Result, err error) {
+	return coreUpsertModel(ctx, db, ptr, updateColumns, checkSQL...)
+}
+func (tx *Transaction) UpsertModel(ctx context.Context, ptr Model, updateColumns []Column, checkSQL ...string) (result sql.Result, err error) {
+	return coreUpsertModel(ctx, tx, ptr, updateColumns, checkSQL...)
+}
+// INSERT INTO ... ON DUPLICATE KEY UPDATE
+// updateColumns 为空时更新除 id 和创建时间以外的所有字段, 更新时间字段(UpdatedAt GMTUpdate UpdateTime)始终会更新
+// 实现了 ScopeTabler 时, 主键冲突的数据属于其他作用域则不会修改任何字段
+func coreUpsertModel(ctx context.Context, storager Storager, ptr Model, updateColumns []Column, checkSQL ...string) (result sql.Result, err error) {
+	qb := QB{
+		Table: ptr,
+	}
+	qb.CheckSQL = checkSQL
+	qb.SQLChecker = storager.getSQLChecker()
+	qb.Dialect = storager.getDialect()
+	qb.scopeCtx = ctx
+	data, err := modelInsertData(ctx, ptr) ; if err != nil {
+		return
+	}
+	for i, column := range data.columns {
+		qb.Insert = append(qb.Insert, Insert{Column: column, Value: data.values[i]})
+	}
+	autoUpdateColumns := len(updateColumns) == 0
+	rValue := reflect.ValueOf(ptr)
+	eachField(rValue.Elem(), rValue.Elem().Type(), func(column string, fieldType reflect.StructField, fieldValue reflect.Value) {
+		if column == "id" {
+			qb.ConflictColumn = []Column{"id"}
+			return
+		}
+		for _, timeField := range updateTimeField {
+			if fieldType.Name == timeField {
+				for _, updateColumn := range updateColumns {
+					if updateColumn.String() == column {
+						return
+					}
+				}
+				updateColumns = append(updateColumns, Column(column))
+				return
+			}
+		}
+		for _, timeField := range createTimeField {
+			if fieldType.Name == timeField {
+				return
+			}
+		}
+		if autoUpdateColumns {
+			updateColumns = append(updateColumns, Column(column))
+		}
+	})
+	if primaryKeyer, ok := ptr.(WherePrimaryKeyer); ok && len(qb.ConflictColumn) == 0 {
+		for _, condition := range primaryKeyer.WherePrimaryKey() {
+			if condition.Column != "" {
+				qb.ConflictColumn = append(qb.ConflictColumn, condition.Column)
+			}
+		}
+	}
+	// 没有需要更新的字段时会生成不带 ON DUPLICATE KEY UPDATE 的 INSERT, 主键冲突时返回错误而不是更新
+	if len(updateColumns) == 0 {
+		return nil, errors.New("goclub/sql: UpsertModel(ctx, ptr, updateColumns) " + reflect.TypeOf(ptr).String() + " has no column to update, use QB.InsertIgnore")
+	}
+	for _, column := range updateColumns {
+		qb.OnDuplicateKeyUpdate = append(qb.OnDuplicateKeyUpdate, SetInsertValue(column))
+	}
+	raw, err := qb.build(Statement("").Enum().Insert) ; if err != nil {
+		return
+	}
+	result, err = execContext(ctx, storager, raw.Query, raw.Values) ; if err != nil {
 		return
 	}
 	err = ptr.AfterCreate(result) ; if err != nil {
@@ -98,6 +369,77 @@ func coreInsertModel(ctx context.Context, storager Storager, ptr Model, checkSQL
 	}
 	return
 }
+func (db *Database) InsertModels(ctx context.Context, slicePtr interface{}, checkSQL ...string) (err error) {
+	return coreInsertModels(ctx, db, slicePtr, checkSQL...)
+}
+func (tx *Transaction) InsertModels(ctx context.Context, slicePtr interface{}, checkSQL ...string) (err error) {
+	return coreInsertModels(ctx, tx, slicePtr, checkSQL...)
+}
+// slicePtr 支持 *[]User 和 *[]*User
+func coreInsertModels(ctx context.Context, storager Storager, slicePtr interface{}, checkSQL ...string) (err error) {
+	models := modelsOfSlicePtr(slicePtr, "InsertModels(ctx, slicePtr)")
+	if len(models) == 0 {
+		return
+	}
+	qb := QB{
+		Table: models[0],
+	}
+	qb.CheckSQL = checkSQL
+	qb.SQLChecker = storager.getSQLChecker()
+	qb.Dialect = storager.getDialect()
+	qb.scopeCtx = ctx
+	var inserts []modelInsert
+	for _, model := range models {
+		data, err := modelInsertData(ctx, model) ; if err != nil {
+			return err
+		}
+		if len(inserts) != 0 && len(data.columns) != len(inserts[0].columns) {
+			return errors.New("goclub/sql: InsertModels(ctx, slicePtr) `sq:\"autoincr\"` field must be all zero or all non-zero")
+		}
+		inserts = append(inserts, data)
+		qb.InsertMultiple.Column = data.columns
+		qb.InsertMultiple.Values = append(qb.InsertMultiple.Values, data.values)
+	}
+	// 批量插入拆分为多条 SQL 执行，需要保证原子性时应在事务中执行
+	var results batchResult
+	rowOffset := 0
+	chunks := qb.InsertMultiple.chunk(coalesceDialect(qb.Dialect).MaxPlaceholders(), storager.getMaxAllowedPacket())
+	for _, chunk := range chunks {
+		chunkQB := qb
+		chunkQB.InsertMultiple = chunk
+		var result sql.Result
+		result, err = execInsertModels(ctx, storager, chunkQB, inserts[rowOffset:rowOffset+len(chunk.Values)]) ; if err != nil {
+			return
+		}
+		results = append(results, result)
+		rowOffset += len(chunk.Values)
+	}
+	for _, model := range models {
+		err = model.AfterCreate(results) ; if err != nil {
+			return
+		}
+	}
+	return
+}
+func modelsOfSlicePtr(slicePtr interface{}, funcName string) (models []Model) {
+	rValue := reflect.ValueOf(slicePtr)
+	if rValue.Kind() != reflect.Ptr || rValue.Elem().Kind() != reflect.Slice {
+		panic(errors.New("goclub/sql: " + funcName + " " + rValue.Type().String() + " must be slice ptr"))
+	}
+	sliceValue := rValue.Elem()
+	for i:=0;i<sliceValue.Len();i++ {
+		item := sliceValue.Index(i)
+		if item.Kind() != reflect.Ptr {
+			item = item.Addr()
+		}
+		model, ok := item.Interface().(Model)
+		if !ok {
+			panic(errors.New("goclub/sql: " + funcName + " " + item.Type().String() + " must implement sq.Model"))
+		}
+		models = append(models, model)
+	}
+	return
+}
 func eachField(elemValue reflect.Value, elemType reflect.Type, handle func(column string, fieldType reflect.StructField, fieldValue reflect.Value)) {
 	for i:=0;i<elemType.NumField();i++ {
 		fieldType := elemType.Field(i)
@@ -132,15 +474,16 @@ func (tx *Transaction) QueryRowScan(ctx context.Context, qb QB, desc ...interfac
 }
 func coreQueryRowScan(ctx context.Context, storager Storager, qb QB, desc ...interface{}) (has bool, err error) {
 	qb.SQLChecker = storager.getSQLChecker()
+	qb.Dialect = storager.getDialect()
+	qb.scopeCtx = ctx
 	qb.Limit = 1
-	raw := qb.SQLSelect()
-	query, values := raw.Query, raw.Values
-	row := storager.getCore().QueryRowxContext(ctx, query, values...)
-	scanErr := row.Scan(desc...)
-	has, err = CheckRowScanErr(scanErr) ; if err != nil {
+	raw, err := qb.build(Statement("").Enum().Select) ; if err != nil {
 		return
 	}
-	return
+	query, values := raw.Query, raw.Values
+	return queryRowScan(ctx, storager, query, values, func(rows *sqlx.Rows) error {
+		return rows.Scan(desc...)
+	})
 }
 func (db *Database) QuerySliceScaner(ctx context.Context, qb QB, scan Scaner) (err error){
 	err = qb.mustInTransaction() ; if err != nil {return}
@@ -149,21 +492,25 @@ func (db *Database) QuerySliceScaner(ctx context.Context, qb QB, scan Scaner) (e
 func (tx *Transaction) QuerySliceScaner(ctx context.Context, qb QB, scan Scaner) (error){
 	return coreQuerySliceScaner(ctx, tx, qb, scan)
 }
-func coreQuerySliceScaner(ctx context.Context, storager Storager, qb QB, scan Scaner) (error) {
+func coreQuerySliceScaner(ctx context.Context, storager Storager, qb QB, scan Scaner) (err error) {
 	qb.SQLChecker = storager.getSQLChecker()
-	raw := qb.SQLSelect()
+	qb.Dialect = storager.getDialect()
+	qb.scopeCtx = ctx
+	raw, err := qb.build(Statement("").Enum().Select) ; if err != nil {
+		return
+	}
 	query, values := raw.Query, raw.Values
-	rows, err := storager.getCore().QueryxContext(ctx, query, values...) ; if err != nil {
+	rows, err := queryxContext(ctx, storager, query, values) ; if err != nil {
 		return  err
 	}
 	defer func() {
-		err = rows.Close()
-		if err != nil {
-			return
+		closeErr := rows.Close()
+		if closeErr != nil && err == nil {
+			err = closeErr
 		}
 	}()
 	for rows.Next() {
-		err := scan(rows) ; if err != nil {
+		err = scan(rows) ; if err != nil {
 			return err
 		}
 	}
@@ -174,34 +521,48 @@ func coreQuerySliceScaner(ctx context.Context, storager Storager, qb QB, scan Sc
 }
 func (db *Database) QueryStruct(ctx context.Context, ptr Tabler, qb QB)  (has bool, err error) {
 	err = qb.mustInTransaction() ; if err != nil {return}
-	return coreQueryStruct(ctx, db,ptr, qb)
+	return coreQueryStruct(replicaRead(ctx, qb), db, ptr, qb)
 }
 func (tx *Transaction) QueryStruct(ctx context.Context, ptr Tabler, qb QB)  (has bool, err error) {
 	return coreQueryStruct(ctx, tx, ptr, qb)
 }
 func coreQueryStruct(ctx context.Context, storager Storager, ptr Tabler, qb QB)  (has bool, err error) {
 	qb.SQLChecker = storager.getSQLChecker()
+	qb.Dialect = storager.getDialect()
+	qb.scopeCtx = ctx
 	qb.Limit = 1
 	qb.Table = ptr
-	raw := qb.SQLSelect()
+	raw, err := qb.build(Statement("").Enum().Select) ; if err != nil {
+		return
+	}
 	query, values := raw.Query, raw.Values
-	row := storager.getCore().QueryRowxContext(ctx, query, values...)
-	scanErr := row.StructScan(ptr)
-	has, err = CheckRowScanErr(scanErr) ; if err != nil {
+	has, err = queryRowScan(ctx, storager, query, values, func(rows *sqlx.Rows) error {
+		return rows.StructScan(ptr)
+	}) ; if err != nil {
 		return
 	}
+	if has {
+		err = afterQuery(ptr) ; if err != nil {
+			return
+		}
+		err = preload(ctx, storager, []reflect.Value{reflect.ValueOf(ptr).Elem()}, qb.Preload, qb.Debug) ; if err != nil {
+			return
+		}
+	}
 	return
 }
 
 func (db *Database) QuerySlice(ctx context.Context, slicePtr interface{}, qb QB) (err error){
 	err = qb.mustInTransaction() ; if err != nil {return}
-	return coreQuerySlice(ctx, db, slicePtr, qb)
+	return coreQuerySlice(replicaRead(ctx, qb), db, slicePtr, qb)
 }
 func (tx *Transaction) QuerySlice(ctx context.Context, slicePtr interface{}, qb QB) (err error){
 	return coreQuerySlice(ctx, tx, slicePtr, qb)
 }
 func coreQuerySlice(ctx context.Context, storager Storager, slicePtr interface{}, qb QB) (err error) {
 	qb.SQLChecker = storager.getSQLChecker()
+	qb.Dialect = storager.getDialect()
+	qb.scopeCtx = ctx
 	ptrType := reflect.TypeOf(slicePtr)
 	if ptrType.Kind() != reflect.Ptr {
 		panic(errors.New("goclub/sql: " + ptrType.String() + "not pointer"))
@@ -212,33 +573,78 @@ func coreQuerySlice(ctx context.Context, storager Storager, slicePtr interface{}
 	if qb.Table == nil {
 		qb.Table = tablerInterface
 	}
-	raw := qb.SQLSelect()
+	raw, err := qb.build(Statement("").Enum().Select) ; if err != nil {
+		return
+	}
 	query, values := raw.Query, raw.Values
-	return storager.getCore().SelectContext(ctx, slicePtr, query, values...)
+	err = selectContext(ctx, storager, slicePtr, query, values) ; if err != nil {
+		return
+	}
+	err = afterQuerySlice(slicePtr) ; if err != nil {
+		return
+	}
+	return preload(ctx, storager, sliceElems(slicePtr), qb.Preload, qb.Debug)
+}
+// 如果 ptr 实现了 AfterQueryer 则触发 AfterQuery, 如果组合了 sq.Tracker 则保存快照
+func afterQuery(ptr interface{}) (err error) {
+	if afterQueryer, ok := ptr.(AfterQueryer); ok {
+		err = afterQueryer.AfterQuery() ; if err != nil {
+			return
+		}
+	}
+	if trackable, ok := ptr.(Trackable); ok {
+		Track(trackable)
+	}
+	return
+}
+// 对 *[]T 或 *[]*T 中的每一项触发 afterQuery
+func afterQuerySlice(slicePtr interface{}) (err error) {
+	sliceValue := reflect.ValueOf(slicePtr).Elem()
+	elemType := sliceValue.Type().Elem()
+	afterQueryerType := reflect.TypeOf((*AfterQueryer)(nil)).Elem()
+	trackableType := reflect.TypeOf((*Trackable)(nil)).Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	ptrType := elemType
+	if !isPtr {
+		ptrType = reflect.PtrTo(elemType)
+	}
+	if !ptrType.Implements(afterQueryerType) && !ptrType.Implements(trackableType) {
+		return
+	}
+	for i:=0;i<sliceValue.Len();i++ {
+		item := sliceValue.Index(i)
+		if !isPtr {
+			item = item.Addr()
+		}
+		err = afterQuery(item.Interface()) ; if err != nil {
+			return
+		}
+	}
+	return
 }
 func (db *Database) Count(ctx context.Context, qb QB) (count uint64, err error){
 	err = qb.mustInTransaction() ; if err != nil {return}
-	return coreCount(ctx, db, qb)
+	return coreCount(replicaRead(ctx, qb), db, qb)
 }
 func (tx *Transaction) Count(ctx context.Context, qb QB) (count uint64, err error){
 	return coreCount(ctx, tx, qb)
 }
 func coreCount(ctx context.Context, storager Storager, qb QB) (count uint64, err error) {
+	qb.scopeCtx = ctx
 	qb.SelectRaw = []Raw{{"COUNT(*)", nil}}
 	qb.limitRaw = limitRaw{Valid: true, Limit: 0}
 	var has bool
 	has, err = coreQueryRowScan(ctx, storager, qb, &count);if err != nil {return }
 	if has == false {
 		raw := qb.SQLSelect()
-		query := raw.Query
-		panic(errors.New("goclub/sql: Count() " + query + "not found data"))
+		return 0, errors.New("goclub/sql: Count() " + raw.Query + " not found data")
 	}
 	return
 }
 // if you need query data exited SELECT "has" FROM user WHERE id = ? better than SELECT count(*) FROM user where id = ?
 func (db *Database) Has(ctx context.Context, qb QB) (has bool, err error){
 	err = qb.mustInTransaction() ; if err != nil {return}
-	return coreHas(ctx, db, qb)
+	return coreHas(replicaRead(ctx, qb), db, qb)
 }
 func (tx *Transaction) Has(ctx context.Context, qb QB) (has bool, err error){
 	return coreHas(ctx, tx, qb)
@@ -249,7 +655,7 @@ func coreHas(ctx context.Context, storager Storager, qb QB) (has bool, err error
 	return coreQueryRowScan(ctx, storager, qb, &i)
 }
 func (db *Database) Sum(ctx context.Context, column Column ,qb QB) (value sql.NullInt64, err error) {
-	return coreSum(ctx, db, column, qb)
+	return coreSum(replicaRead(ctx, qb), db, column, qb)
 }
 func (tx *Transaction) Sum(ctx context.Context, column Column ,qb QB) (value sql.NullInt64, err error) {
 	return coreSum(ctx, tx, column, qb)
@@ -306,9 +712,13 @@ func (tx *Transaction) Update(ctx context.Context, qb QB) (result sql.Result, er
 }
 func coreUpdate(ctx context.Context, storager Storager, qb QB) (result sql.Result, err error) {
 	qb.SQLChecker = storager.getSQLChecker()
-	raw := qb.SQLUpdate()
+	qb.Dialect = storager.getDialect()
+	qb.scopeCtx = ctx
+	raw, err := qb.build(Statement("").Enum().Update) ; if err != nil {
+		return
+	}
 	query, values := raw.Query, raw.Values
-	result, err = storager.getCore().ExecContext(ctx, query, values...)
+	result, err = execContext(ctx, storager, query, values)
 	if err != nil {return result, err}
 	return
 }
@@ -325,6 +735,9 @@ func coreUpdateModel(ctx context.Context, storager Storager, ptr Model, updateDa
 	if rType.Kind() != reflect.Ptr {
 		panic(errors.New("UpdateModel(ctx, ptr) " + rType.String() + " must be ptr"))
 	}
+	err = ptr.BeforeUpdate() ; if err != nil {
+		return
+	}
 	elemValue := rValue.Elem()
 	elemType := rType.Elem()
 	primaryIDInfo := struct {
@@ -358,6 +771,8 @@ func coreUpdateModel(ctx context.Context, storager Storager, ptr Model, updateDa
 			}
 		}
 		for dataIndex, data := range updateData {
+			// 闭包中使用的 data 需要是每次循环的副本
+			data := data
 			if len(data.Column) != 0  && column == data.Column.String() {
 					if data.OnUpdated == nil {
 						updateData[dataIndex].OnUpdated = func() error {
@@ -372,16 +787,40 @@ func coreUpdateModel(ctx context.Context, storager Storager, ptr Model, updateDa
 		return
 	}
 	wheres := append(primaryKeyWhere, where...)
+	// 乐观锁
+	versionColumn, versionValue, hasVersion := versionField(elemValue)
+	if hasVersion {
+		updateData = append(updateData, Update{
+			Raw: Raw{versionColumn.wrapField() + " = " + versionColumn.wrapField() + " + 1", nil},
+			OnUpdated: func() error {
+				increaseVersion(versionValue)
+				return nil
+			},
+		})
+		wheres = append(wheres, Condition{versionColumn, Equal(versionValue.Interface())})
+	}
 	qb := QB{
 		Table: ptr,
 		Update: updateData,
 		Where: wheres,
 	}
 	qb.SQLChecker = storager.getSQLChecker()
-	raw := qb.SQLUpdate()
+	qb.Dialect = storager.getDialect()
+	qb.scopeCtx = ctx
+	raw, err := qb.build(Statement("").Enum().Update) ; if err != nil {
+		return
+	}
 	query, values := raw.Query, raw.Values
-	result, err = storager.getCore().ExecContext(ctx, query, values...)
+	result, err = execContext(ctx, storager, query, values)
 	if err != nil {return result, err}
+	if hasVersion {
+		affected, affectedErr := result.RowsAffected() ; if affectedErr != nil {
+			return result, affectedErr
+		}
+		if affected == 0 {
+			return result, ErrStaleObject
+		}
+	}
 	for _, data := range updateData {
 		if data.OnUpdated != nil {
 			updatedErr := data.OnUpdated() ; if updatedErr != nil {
@@ -389,6 +828,9 @@ func coreUpdateModel(ctx context.Context, storager Storager, ptr Model, updateDa
 			}
 		}
 	}
+	err = ptr.AfterUpdate() ; if err != nil {
+		return
+	}
 	return
 }
 func (db *Database) checkIsTestDatabase(ctx context.Context) (err error) {
@@ -421,8 +863,12 @@ func (tx *Transaction) HardDelete(ctx context.Context, qb QB) (result sql.Result
 }
 func coreHardDelete(ctx context.Context, storager Storager, qb QB) (result sql.Result, err error) {
 	qb.SQLChecker = storager.getSQLChecker()
-	raw := qb.SQLDelete()
-	return storager.getCore().ExecContext(ctx, raw.Query, raw.Values...)
+	qb.Dialect = storager.getDialect()
+	qb.scopeCtx = ctx
+	raw, err := qb.build(Statement("").Enum().Delete) ; if err != nil {
+		return
+	}
+	return execContext(ctx, storager, raw.Query, raw.Values)
 }
 func (db *Database) HardDeleteModel(ctx context.Context, ptr Model, checkSQL ...string) (result sql.Result, err error){
 	return coreHardDeleteModel(ctx,db, ptr, checkSQL...)
@@ -458,15 +904,37 @@ func coreHardDeleteModel(ctx context.Context, storager Storager, ptr Model, chec
 	primaryKeyWhere, err := primaryKeyWhere(ptr, primaryIDInfo, elemType.Name()) ; if err != nil {
 		return
 	}
+	if beforeDeleter, ok := ptr.(BeforeDeleter); ok {
+		err = beforeDeleter.BeforeDelete() ; if err != nil {
+			return
+		}
+	}
 	qb := QB{
 		Table: ptr,
 		Where: primaryKeyWhere,
-		Limit: 1,
+		Limit: modelLimit(storager.getDialect()),
 	}
 	qb.CheckSQL = checkSQL
 	qb.SQLChecker = storager.getSQLChecker()
-	raw := qb.SQLDelete()
-	return storager.getCore().ExecContext(ctx, raw.Query, raw.Values...)
+	qb.Dialect = storager.getDialect()
+	qb.scopeCtx = ctx
+	raw, err := qb.build(Statement("").Enum().Delete) ; if err != nil {
+		return
+	}
+	result, err = execContext(ctx, storager, raw.Query, raw.Values) ; if err != nil {
+		return
+	}
+	err = afterDelete(ptr, result) ; if err != nil {
+		return
+	}
+	return
+}
+// 如果 ptr 实现了 AfterDeleter 则触发 AfterDelete
+func afterDelete(ptr Model, result sql.Result) error {
+	if afterDeleter, ok := ptr.(AfterDeleter); ok {
+		return afterDeleter.AfterDelete(result)
+	}
+	return nil
 }
 func (db *Database) SoftDelete(ctx context.Context, qb QB) (result sql.Result, err error) {
 	return coreSoftDelete(ctx, db, qb)
@@ -475,11 +943,16 @@ func (tx *Transaction) SoftDelete(ctx context.Context, qb QB) (result sql.Result
 	return coreSoftDelete(ctx, tx, qb)
 }
 func coreSoftDelete(ctx context.Context, storager Storager, qb QB) (result sql.Result, err error) {
+	qb.SQLChecker = storager.getSQLChecker()
+	qb.Dialect = storager.getDialect()
+	qb.scopeCtx = ctx
 	qb.Update = []Update{
 		{Raw: qb.Table.SoftDeleteSet(),},
 	}
-	raw := qb.SQLUpdate()
-	return storager.getCore().ExecContext(ctx, raw.Query, raw.Values...)
+	raw, err := qb.build(Statement("").Enum().Update) ; if err != nil {
+		return
+	}
+	return execContext(ctx, storager, raw.Query, raw.Values)
 }
 func (db *Database) SoftDeleteModel(ctx context.Context, ptr Model, checkSQL ...string) (result sql.Result, err error){
 	return coreSoftDeleteModel(ctx, db, ptr, checkSQL...)
@@ -515,27 +988,121 @@ func coreSoftDeleteModel(ctx context.Context, storager Storager, ptr Model, chec
 	primaryKeyWhere, err := primaryKeyWhere(ptr, primaryIDInfo, elemType.Name()) ; if err != nil {
 		return
 	}
+	if beforeSoftDeleter, ok := ptr.(BeforeSoftDeleter); ok {
+		err = beforeSoftDeleter.BeforeSoftDelete() ; if err != nil {
+			return
+		}
+	}
 	qb := QB{
 		Table: ptr,
 		Where: primaryKeyWhere,
 		Update: []Update{{Raw:ptr.SoftDeleteSet(),}},
-		Limit: 1,
+		Limit: modelLimit(storager.getDialect()),
 	}
 	qb.CheckSQL = checkSQL
 	qb.SQLChecker = storager.getSQLChecker()
-	raw := qb.SQLUpdate()
-	return storager.getCore().ExecContext(ctx, raw.Query, raw.Values...)
-
+	qb.Dialect = storager.getDialect()
+	qb.scopeCtx = ctx
+	raw, err := qb.build(Statement("").Enum().Update) ; if err != nil {
+		return
+	}
+	result, err = execContext(ctx, storager, raw.Query, raw.Values) ; if err != nil {
+		return
+	}
+	err = afterDelete(ptr, result) ; if err != nil {
+		return
+	}
+	return
+}
+// 恢复软删除的数据, qb.Table 需要实现 SoftDeleteRestorer
+// UPDATE `user` SET `deleted_at` = NULL WHERE `id` = ? AND `deleted_at` IS NOT NULL
+func (db *Database) Restore(ctx context.Context, qb QB) (result sql.Result, err error) {
+	return coreRestore(ctx, db, qb)
+}
+func (tx *Transaction) Restore(ctx context.Context, qb QB) (result sql.Result, err error) {
+	return coreRestore(ctx, tx, qb)
+}
+func coreRestore(ctx context.Context, storager Storager, qb QB) (result sql.Result, err error) {
+	restorer, ok := qb.Table.(SoftDeleteRestorer) ; if !ok {
+		return nil, errors.New("goclub/sql: Restore(ctx, qb) qb.Table must implements sq.SoftDeleteRestorer")
+	}
+	qb.SQLChecker = storager.getSQLChecker()
+	qb.Dialect = storager.getDialect()
+	qb.scopeCtx = ctx
+	qb.Update = []Update{
+		{Raw: restorer.SoftDeleteRestoreSet(),},
+	}
+	qb.DisableSoftDelete = false
+	qb.SoftDeleteMode = OnlyTrashed
+	raw, err := qb.build(Statement("").Enum().Update) ; if err != nil {
+		return
+	}
+	return execContext(ctx, storager, raw.Query, raw.Values)
+}
+func (db *Database) RestoreModel(ctx context.Context, ptr Model, checkSQL ...string) (result sql.Result, err error){
+	return coreRestoreModel(ctx, db, ptr, checkSQL...)
+}
+func (tx *Transaction) RestoreModel(ctx context.Context, ptr Model, checkSQL ...string) (result sql.Result, err error){
+	return coreRestoreModel(ctx, tx, ptr, checkSQL...)
+}
+func coreRestoreModel(ctx context.Context, storager Storager, ptr Model, checkSQL ...string) (result sql.Result, err error) {
+	rValue := reflect.ValueOf(ptr)
+	rType := rValue.Type()
+	if rType.Kind() != reflect.Ptr {
+		panic(errors.New("RestoreModel(ctx, ptr) " + rType.String() + " must be ptr"))
+	}
+	restorer, ok := ptr.(SoftDeleteRestorer) ; if !ok {
+		return nil, errors.New("goclub/sql: RestoreModel(ctx, ptr) " + rType.String() + " must implements sq.SoftDeleteRestorer")
+	}
+	elemValue := rValue.Elem()
+	elemType := rType.Elem()
+	primaryIDInfo := struct {
+		HasID bool
+		IDValue interface{}
+	}{}
+	for i:=0;i<elemType.NumField();i++ {
+		fieldType := elemType.Field(i)
+		fieldValue := elemValue.Field(i)
+		column, hasDBTag := fieldType.Tag.Lookup("db")
+		if !hasDBTag {
+			continue
+		}
+		// find primary id
+		if column == "id" {
+			primaryIDInfo.HasID = true
+			primaryIDInfo.IDValue = fieldValue.Interface()
+		}
+	}
+	primaryKeyWhere, err := primaryKeyWhere(ptr, primaryIDInfo, elemType.Name()) ; if err != nil {
+		return
+	}
+	qb := QB{
+		Table: ptr,
+		Where: primaryKeyWhere,
+		Update: []Update{{Raw: restorer.SoftDeleteRestoreSet(),}},
+		SoftDeleteMode: OnlyTrashed,
+		Limit: modelLimit(storager.getDialect()),
+	}
+	qb.CheckSQL = checkSQL
+	qb.SQLChecker = storager.getSQLChecker()
+	qb.Dialect = storager.getDialect()
+	qb.scopeCtx = ctx
+	raw, err := qb.build(Statement("").Enum().Update) ; if err != nil {
+		return
+	}
+	return execContext(ctx, storager, raw.Query, raw.Values)
 }
 func (db *Database) QueryRelation(ctx context.Context, ptr Relation, qb QB) (has bool, err error){
 	err = qb.mustInTransaction() ; if err != nil {return}
-	return coreQueryRelation(ctx, db, ptr, qb)
+	return coreQueryRelation(replicaRead(ctx, qb), db, ptr, qb)
 }
 func (tx *Transaction) QueryRelation(ctx context.Context, ptr Relation, qb QB) (has bool, err error){
 	return coreQueryRelation(ctx, tx, ptr, qb)
 }
 func coreQueryRelation(ctx context.Context, storager Storager, ptr Relation, qb QB) (has bool, err error) {
 	qb.SQLChecker = storager.getSQLChecker()
+	qb.Dialect = storager.getDialect()
+	qb.scopeCtx = ctx
 	qb.Select = TagToColumns(ptr)
 	table := table {
 		tableName: ptr.TableName(),
@@ -543,31 +1110,38 @@ func coreQueryRelation(ctx context.Context, storager Storager, ptr Relation, qb
 		// Relation 不需要 update
 		softDeleteSet: func() Raw {return Raw{}},
 	}
+	table.scopeTabler, _ = ptr.(ScopeTabler)
 	qb.Table = table
 	qb.Limit = 1
 	qb.Join = ptr.RelationJoin()
 
-	qb.SQLChecker = storager.getSQLChecker()
-	qb.Limit = 1
-
-	raw := qb.SQLSelect()
+	raw, err := qb.build(Statement("").Enum().Select) ; if err != nil {
+		return
+	}
 	query, values := raw.Query, raw.Values
-	row := storager.getCore().QueryRowxContext(ctx, query, values...)
-	scanErr := row.StructScan(ptr)
-	has, err = CheckRowScanErr(scanErr) ; if err != nil {
+	has, err = queryRowScan(ctx, storager, query, values, func(rows *sqlx.Rows) error {
+		return rows.StructScan(ptr)
+	}) ; if err != nil {
 		return
 	}
+	if has {
+		err = afterQuery(ptr) ; if err != nil {
+			return
+		}
+	}
 	return
 }
 func (db *Database) QueryRelationSlice(ctx context.Context, relationSlicePtr interface{}, qb QB) (err error) {
 	err = qb.mustInTransaction() ; if err != nil {return}
-	return coreQueryRelationSlice(ctx, db, relationSlicePtr, qb)
+	return coreQueryRelationSlice(replicaRead(ctx, qb), db, relationSlicePtr, qb)
 }
 func (tx *Transaction) QueryRelationSlice(ctx context.Context, relationSlicePtr interface{}, qb QB) (err error) {
 	return coreQueryRelationSlice(ctx, tx, relationSlicePtr, qb)
 }
 func coreQueryRelationSlice(ctx context.Context, storager Storager, relationSlicePtr interface{}, qb QB) (err error) {
 	qb.SQLChecker = storager.getSQLChecker()
+	qb.Dialect = storager.getDialect()
+	qb.scopeCtx = ctx
 	ptrType := reflect.TypeOf(relationSlicePtr)
 	if ptrType.Kind() != reflect.Ptr {
 		panic(errors.New("goclub/sql: " + ptrType.String() + "not pointer"))
@@ -577,19 +1151,23 @@ func coreQueryRelationSlice(ctx context.Context, storager Storager, relationSlic
 	tablerInterface := reflectItemValue.Interface().(Relation)
 
 	qb.Select = TagToColumns(tablerInterface)
-	qb.Table = table {
+	relationTable := table {
 		tableName: tablerInterface.TableName(),
 		softDeleteWhere: tablerInterface.SoftDeleteWhere,
 		// Relation 不需要 update
 		softDeleteSet: func() Raw {return Raw{}},
 	}
+	relationTable.scopeTabler, _ = tablerInterface.(ScopeTabler)
+	qb.Table = relationTable
 	qb.Join = tablerInterface.RelationJoin()
-	raw := qb.SQLSelect()
+	raw, err := qb.build(Statement("").Enum().Select) ; if err != nil {
+		return
+	}
 	query, values := raw.Query, raw.Values
-	err = storager.getCore().SelectContext(ctx, relationSlicePtr,query , values...) ; if err != nil {
+	err = selectContext(ctx, storager, relationSlicePtr, query, values) ; if err != nil {
 		return err
 	}
-	return
+	return afterQuerySlice(relationSlicePtr)
 }
 
 func (db *Database) Exec(ctx context.Context, query string, values []interface{}) (result sql.Result, err error) {
@@ -599,7 +1177,7 @@ func (tx *Transaction) Exec(ctx context.Context, query string, values []interfac
 	return coreExec(ctx, tx, query, values)
 }
 func coreExec(ctx context.Context, storager Storager, query string, values []interface{}) (result sql.Result, err error) {
-	return storager.getCore().ExecContext(ctx, query, values... )
+	return execContext(ctx, storager, query, values)
 }
 func (db *Database) ExecQB(ctx context.Context, qb QB, statement Statement) (result sql.Result, err error){
 	return coreExecQB(ctx, db, qb, statement)
@@ -609,8 +1187,12 @@ func (tx *Transaction) ExecQB(ctx context.Context, qb QB, statement Statement) (
 }
 func coreExecQB(ctx context.Context, storager Storager, qb QB, statement Statement) (result sql.Result, err error) {
 	qb.SQLChecker = storager.getSQLChecker()
-	raw := qb.SQL(statement)
-	result, err = storager.getCore().ExecContext(ctx, raw.Query, raw.Values...) ; if err != nil {
+	qb.Dialect = storager.getDialect()
+	qb.scopeCtx = ctx
+	raw, err := qb.build(statement) ; if err != nil {
+		return
+	}
+	result, err = execContext(ctx, storager, raw.Query, raw.Values) ; if err != nil {
 		return
 	}
 	return
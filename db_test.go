@@ -3,7 +3,7 @@ package sq_test
 import (
 	"context"
 	"database/sql"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	sq "github.com/goclub/sql"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
@@ -16,16 +16,17 @@ import (
 
 
 var testDB *sq.Database
+var testDataSourceName = sq.DataSourceName{
+	DriverName: "mysql",
+	User: "root",
+	Password:"somepass",
+	Host: "127.0.0.1",
+	Port:"3306",
+	DB: "test_goclub_sql",
+}.String()
 func init () {
 
-	db, dbClose, err := sq.Open("mysql", sq.DataSourceName{
-		DriverName: "mysql",
-		User: "root",
-		Password:"somepass",
-		Host: "127.0.0.1",
-		Port:"3306",
-		DB: "test_goclub_sql",
-	}.String()) ; if err != nil {
+	db, dbClose, err := sq.Open("mysql", testDataSourceName) ; if err != nil {
 		panic(err)
 	}
 	testDB = db
@@ -128,6 +129,176 @@ func (suite TestDBSuite) TestInsertModel() {
 		assert.True(t, time.Now().Sub(user.CreatedAt) < time.Second)
 		assert.True(t, time.Now().Sub(user.UpdatedAt) < time.Second)
 	}
+	{
+		// 非零值的创建和更新时间不会被覆盖
+		createdAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local)
+		user := User{
+			Name: "TestInsertModel_createdAt",
+		}
+		user.CreatedAt = createdAt
+		user.UpdatedAt = createdAt
+		err := testDB.InsertModel(context.TODO(), &user)
+		assert.NoError(t, err)
+		assert.Equal(t, createdAt, user.CreatedAt)
+		assert.Equal(t, createdAt, user.UpdatedAt)
+	}
+}
+
+func (suite TestDBSuite) TestInsertModelAutoIncrement() {
+	t := suite.T()
+	{
+		log := Log{Message: "TestInsertModelAutoIncrement"}
+		err := testDB.InsertModel(context.TODO(), &log, "INSERT INTO `log` (`message`,`created_at`,`updated_at`) VALUES (?,?,?)")
+		assert.NoError(t, err)
+		assert.NotEqual(t, log.ID, uint64(0))
+		queryLog := Log{}
+		has, err := testDB.QueryStruct(context.TODO(), &queryLog, sq.QB{
+			Where: sq.And("id", sq.Equal(log.ID)),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, has, true)
+		assert.Equal(t, queryLog.Message, "TestInsertModelAutoIncrement")
+	}
+	{
+		logs := []Log{
+			{Message: "TestInsertModelAutoIncrement_1"},
+			{Message: "TestInsertModelAutoIncrement_2"},
+			{Message: "TestInsertModelAutoIncrement_3"},
+		}
+		err := testDB.InsertModels(context.TODO(), &logs)
+		assert.NoError(t, err)
+		assert.NotEqual(t, logs[0].ID, uint64(0))
+		assert.Equal(t, logs[1].ID, logs[0].ID+1)
+		assert.Equal(t, logs[2].ID, logs[0].ID+2)
+		for _, log := range logs {
+			queryLog := Log{}
+			has, err := testDB.QueryStruct(context.TODO(), &queryLog, sq.QB{
+				Where: sq.And("id", sq.Equal(log.ID)),
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, has, true)
+			assert.Equal(t, queryLog.Message, log.Message)
+		}
+	}
+	{
+		// 根据 @@auto_increment_increment 推算批量插入的id
+		logs := []Log{
+			{Message: "TestInsertModelAutoIncrement_increment_1"},
+			{Message: "TestInsertModelAutoIncrement_increment_2"},
+		}
+		_, err := testDB.Transaction(context.TODO(), func(tx *sq.Transaction) sq.TxResult {
+			_, err := tx.Exec(context.TODO(), "SET SESSION auto_increment_increment = 2", nil) ; if err != nil {
+				return tx.RollbackWithError(err)
+			}
+			err = tx.InsertModels(context.TODO(), &logs)
+			// 连接会回到连接池, 需要恢复会话变量
+			_, resetErr := tx.Exec(context.TODO(), "SET SESSION auto_increment_increment = 1", nil) ; if err == nil {
+				err = resetErr
+			}
+			if err != nil {
+				return tx.RollbackWithError(err)
+			}
+			return tx.Commit()
+		})
+		assert.NoError(t, err)
+		assert.NotEqual(t, logs[0].ID, uint64(0))
+		assert.Equal(t, logs[1].ID, logs[0].ID+2)
+		queryLog := Log{}
+		has, err := testDB.QueryStruct(context.TODO(), &queryLog, sq.QB{
+			Where: sq.And("id", sq.Equal(logs[1].ID)),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, has, true)
+		assert.Equal(t, queryLog.Message, logs[1].Message)
+	}
+}
+func (suite TestDBSuite) TestUpsertModel() {
+	t := suite.T()
+	userCol := User{}.Column()
+	{
+		_, err := testDB.ClearTestData(context.TODO(), sq.QB{
+			Table: User{},
+			Where: sq.And(userCol.Name, sq.LikeLeft("TestUpsertModel")),
+			CheckSQL:[]string{"DELETE FROM `user` WHERE `name` LIKE ?"},
+		})
+		assert.NoError(t, err)
+	}
+	user := User{Name: "TestUpsertModel", Age: 18}
+	{
+		_, err := testDB.UpsertModel(context.TODO(), &user, []sq.Column{userCol.Age},
+			"INSERT INTO `user` (`id`,`name`,`age`,`created_at`,`updated_at`) VALUES (?,?,?,?,?) ON DUPLICATE KEY UPDATE `age` = VALUES(`age`),`updated_at` = VALUES(`updated_at`)",
+		)
+		assert.NoError(t, err)
+	}
+	{
+		user.Age = 20
+		result, err := testDB.UpsertModel(context.TODO(), &user, []sq.Column{userCol.Age})
+		assert.NoError(t, err)
+		affected, err := result.RowsAffected()
+		assert.NoError(t, err)
+		// ON DUPLICATE KEY UPDATE 更新数据时 affected 为 2
+		assert.Equal(t, affected, int64(2))
+	}
+	{
+		queryUser := User{}
+		has, err := testDB.QueryStruct(context.TODO(), &queryUser, sq.QB{
+			Where: sq.And(userCol.ID, sq.Equal(user.ID)),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, has, true)
+		assert.Equal(t, queryUser.Age, 20)
+	}
+	{
+		_, err := testDB.UpsertModel(context.TODO(), &LogID{ID: 1}, nil)
+		assert.EqualError(t, err, "goclub/sql: UpsertModel(ctx, ptr, updateColumns) *sq_test.LogID has no column to update, use QB.InsertIgnore")
+	}
+}
+func (suite TestDBSuite) TestInsertModels() {
+	t := suite.T()
+	userCol := User{}.Column()
+	{
+		_, err := testDB.ClearTestData(context.TODO(), sq.QB{
+			Table: User{},
+			Where: sq.And(userCol.Name, sq.LikeLeft("TestInsertModels")),
+			CheckSQL:[]string{"DELETE FROM `user` WHERE `name` LIKE ?"},
+		})
+		assert.NoError(t, err)
+	}
+	{
+		users := []User{
+			{Name: "TestInsertModels_1", Age: 18},
+			{Name: "TestInsertModels_2", Age: 19},
+		}
+		err := testDB.InsertModels(
+			context.TODO(),
+			&users,
+			"INSERT INTO `user` (`id`,`name`,`age`,`created_at`,`updated_at`) VALUES (?,?,?,?,?),(?,?,?,?,?)",
+		)
+		assert.NoError(t, err)
+		for _, user := range users {
+			assert.NotEqual(t, user.ID, IDUser(""))
+			assert.True(t, time.Now().Sub(user.CreatedAt) < time.Second)
+		}
+	}
+	{
+		var users []*User
+		for i:=0;i<10;i++ {
+			users = append(users, &User{Name: "TestInsertModels_chunk_" + strconv.Itoa(i)})
+		}
+		// 每行数据约 120 字节, 限制 1024 字节会拆分为多条 SQL
+		testDB.SetMaxAllowedPacket(1024)
+		err := testDB.InsertModels(context.TODO(), &users)
+		testDB.SetMaxAllowedPacket(sq.DefaultMaxAllowedPacket)
+		assert.NoError(t, err)
+	}
+	{
+		count, err := testDB.Count(context.TODO(), sq.QB{
+			Table: User{},
+			Where: sq.And(userCol.Name, sq.LikeLeft("TestInsertModels")),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, count, uint64(12))
+	}
 }
 
 
@@ -297,6 +468,55 @@ func (suite TestDBSuite) TestQuerySliceScaner() {
 	}
 }
 
+func (suite TestDBSuite) TestQueryIterator() {
+	t := suite.T()
+	userCol := User{}.Column()
+	// 清空数据
+	{
+		_, err := testDB.ClearTestData(context.TODO(), sq.QB{
+			Table: User{},
+			Where: sq.And(userCol.Name, sq.LikeLeft("TestQueryIterator")),
+			CheckSQL:[]string{"DELETE FROM `user` WHERE `name` LIKE ?"},
+		})
+		assert.NoError(t, err)
+	}
+	// 插入数据
+	{
+		users := []User{
+			{Name:"TestQueryIterator_1", Age: 20,},
+			{Name:"TestQueryIterator_2", Age: 21,},
+		}
+		err := testDB.InsertModels(context.TODO(), &users)
+		assert.NoError(t, err)
+	}
+	{
+		type Data struct {
+			Name string `db:"name"`
+			Age int `db:"age"`
+			TableUser
+		}
+		iter, err := testDB.QueryIterator(context.TODO(), sq.QB{
+			Where: sq.And(userCol.Name, sq.LikeLeft("TestQueryIterator")),
+			OrderBy: []sq.OrderBy{{userCol.Name, sq.ASC}},
+			CheckSQL: []string{"SELECT `name`, `age` FROM `user` WHERE `name` LIKE ? AND `deleted_at` IS NULL ORDER BY `name` ASC"},
+		}, &Data{})
+		assert.NoError(t, err)
+		var list []Data
+		for iter.Next() {
+			data := Data{}
+			err := iter.StructScan(&data)
+			assert.NoError(t, err)
+			list = append(list, data)
+		}
+		assert.NoError(t, iter.Err())
+		assert.NoError(t, iter.Close())
+		assert.Equal(t, list, []Data{
+			{Name: "TestQueryIterator_1", Age: 20,},
+			{Name: "TestQueryIterator_2", Age: 21,},
+		})
+	}
+}
+
 func (suite TestDBSuite) TestQuerySlice() {
 	t := suite.T()
 	userCol := User{}.Column()
@@ -339,6 +559,130 @@ func (suite TestDBSuite) TestQuerySlice() {
 		})
 	}
 }
+func (suite TestDBSuite) TestQueryKeyset() {
+	t := suite.T()
+	userCol := User{}.Column()
+	{
+		_, err := testDB.ClearTestData(context.TODO(), sq.QB{
+			Table: User{},
+			Where: sq.And(userCol.Name, sq.LikeLeft("TestQueryKeyset")),
+			CheckSQL:[]string{"DELETE FROM `user` WHERE `name` LIKE ?"},
+		})
+		assert.NoError(t, err)
+	}
+	{
+		var users []User
+		for i:=0;i<5;i++ {
+			users = append(users, User{Name: "TestQueryKeyset_" + strconv.Itoa(i), Age: i})
+		}
+		err := testDB.InsertModels(context.TODO(), &users)
+		assert.NoError(t, err)
+	}
+	qb := sq.QB{
+		Where: sq.And(userCol.Name, sq.LikeLeft("TestQueryKeyset")),
+		OrderBy: []sq.OrderBy{{userCol.Age, sq.ASC}, {userCol.ID, sq.ASC}},
+	}
+	names := func(list []User) (names []string) {
+		for _, item := range list {
+			names = append(names, item.Name)
+		}
+		return
+	}
+	var list []User
+	page, err := testDB.QueryKeyset(context.TODO(), &list, qb, "", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"TestQueryKeyset_0", "TestQueryKeyset_1"}, names(list))
+	assert.Equal(t, "", page.Prev)
+	assert.NotEqual(t, "", page.Next)
+
+	list = nil
+	page, err = testDB.QueryKeyset(context.TODO(), &list, qb, page.Next, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"TestQueryKeyset_2", "TestQueryKeyset_3"}, names(list))
+	assert.NotEqual(t, "", page.Prev)
+	assert.NotEqual(t, "", page.Next)
+	prev := page.Prev
+
+	list = nil
+	page, err = testDB.QueryKeyset(context.TODO(), &list, qb, page.Next, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"TestQueryKeyset_4"}, names(list))
+	assert.Equal(t, "", page.Next)
+
+	// 复用 list 时会清空上一页的数据
+	page, err = testDB.QueryKeyset(context.TODO(), &list, qb, prev, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"TestQueryKeyset_0", "TestQueryKeyset_1"}, names(list))
+	assert.Equal(t, "", page.Prev)
+	assert.NotEqual(t, "", page.Next)
+}
+
+func (suite TestDBSuite) TestQueryPage() {
+	t := suite.T()
+	userCol := User{}.Column()
+	{
+		_, err := testDB.ClearTestData(context.TODO(), sq.QB{
+			Table: User{},
+			Where: sq.And(userCol.Name, sq.LikeLeft("TestQueryPage")),
+			CheckSQL:[]string{"DELETE FROM `user` WHERE `name` LIKE ?"},
+		})
+		assert.NoError(t, err)
+	}
+	qb := sq.QB{
+		Where: sq.And(userCol.Name, sq.LikeLeft("TestQueryPage")),
+		OrderBy: []sq.OrderBy{{userCol.ID, sq.ASC}},
+	}
+	{
+		var list []User
+		page, err := testDB.QueryPage(context.TODO(), &list, qb, 1, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, sq.Page{Total: 0, Page: 1, PerPage: 2, TotalPages: 0, HasNext: false}, page)
+		assert.Equal(t, 0, len(list))
+	}
+	{
+		var users []User
+		for i:=0;i<5;i++ {
+			users = append(users, User{Name: "TestQueryPage_" + strconv.Itoa(i), Age: i % 2})
+		}
+		err := testDB.InsertModels(context.TODO(), &users)
+		assert.NoError(t, err)
+	}
+	{
+		var list []User
+		page, err := testDB.QueryPage(context.TODO(), &list, qb, 2, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, sq.Page{Total: 5, Page: 2, PerPage: 2, TotalPages: 3, HasNext: true}, page)
+		assert.Equal(t, 2, len(list))
+		assert.Equal(t, "TestQueryPage_2", list[0].Name)
+	}
+	{
+		var list []User
+		page, err := testDB.QueryPage(context.TODO(), &list, qb, 3, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, sq.Page{Total: 5, Page: 3, PerPage: 2, TotalPages: 3, HasNext: false}, page)
+		assert.Equal(t, 1, len(list))
+	}
+	{
+		// 存在 GROUP BY 时总数为分组的数量
+		var list []User
+		page, err := testDB.QueryPage(context.TODO(), &list, sq.QB{
+			Select: []sq.Column{userCol.Age},
+			Where: sq.And(userCol.Name, sq.LikeLeft("TestQueryPage")),
+			GroupBy: []sq.Column{userCol.Age},
+		}, 1, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, sq.Page{Total: 2, Page: 1, PerPage: 10, TotalPages: 1, HasNext: false}, page)
+		assert.Equal(t, 2, len(list))
+	}
+	{
+		var list []User
+		_, err := testDB.QueryPage(context.TODO(), &list, qb, 0, 2)
+		assert.EqualError(t, err, "goclub/sql: QueryPage(ctx, slicePtr, qb, page, perPage) page and perPage must be greater than 0")
+		_, err = testDB.QueryPage(context.TODO(), &list, qb, 1, 0)
+		assert.EqualError(t, err, "goclub/sql: QueryPage(ctx, slicePtr, qb, page, perPage) page and perPage must be greater than 0")
+	}
+}
+
 func (suite TestDBSuite) TestCount() {
 	t := suite.T()
 	userCol := User{}.Column()
@@ -752,95 +1096,299 @@ func (suite TestDBSuite) TestUpdateModel() {
 	}
 }
 
-func (suite TestDBSuite) TestHardDelete() {
+func (suite TestDBSuite) TestUpdateModelVersion() {
 	t := suite.T()
-	userCol := User{}.Column()
+	article := Article{Title: "TestUpdateModelVersion"}
 	{
-		_, err := testDB.ClearTestData(context.TODO(), sq.QB{
-			Table: User{},
-			Where: sq.And(userCol.Name, sq.Like("TestHardDelete")),
-			CheckSQL:[]string{"DELETE FROM `user` WHERE `name` LIKE ?"},
+		err := testDB.InsertModel(context.TODO(), &article, "INSERT INTO `article` (`title`,`version`) VALUES (?,?)")
+		assert.NoError(t, err)
+	}
+	stale := article
+	{
+		_, err := testDB.UpdateModel(context.TODO(), &article, []sq.Update{
+			sq.Set("title", "TestUpdateModelVersion_1"),
+		}, nil, "UPDATE `article` SET `title`=?,`version` = `version` + 1 WHERE `id` = ? AND `version` = ?")
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(1), article.Version.Version)
+		assert.Equal(t, "TestUpdateModelVersion_1", article.Title)
+	}
+	{
+		_, err := testDB.UpdateModel(context.TODO(), &stale, []sq.Update{
+			sq.Set("title", "TestUpdateModelVersion_2"),
+		}, nil)
+		assert.Equal(t, sq.ErrStaleObject, err)
+		assert.Equal(t, uint64(0), stale.Version.Version)
+		assert.Equal(t, "TestUpdateModelVersion", stale.Title)
+	}
+	{
+		result := Article{}
+		has, err := testDB.QueryStruct(context.TODO(), &result, sq.QB{
+			Where: sq.And("id", sq.Equal(article.ID)),
 		})
 		assert.NoError(t, err)
-
+		assert.True(t, has)
+		assert.Equal(t, uint64(1), result.Version.Version)
+		assert.Equal(t, "TestUpdateModelVersion_1", result.Title)
 	}
+}
+func (suite TestDBSuite) TestIncrementDecrementModel() {
+	t := suite.T()
+	goodsCol := Goods{}.Column()
+	goods := Goods{Stock: 2, Price: 1.5}
 	{
-		count, err := testDB.Count(context.TODO(), sq.QB{
-			Table:             User{},
-			DisableSoftDelete: true,
-			Where:             sq.And(userCol.Name, sq.LikeLeft("TestHardDelete")),
-			CheckSQL:          []string{"SELECT COUNT(*) FROM `user` WHERE `name` LIKE ?"},
+		err := testDB.InsertModel(context.TODO(), &goods)
+		assert.NoError(t, err)
+	}
+	for i := 0; i < 3; i++ {
+		affected, err := testDB.IncrementIntModel(context.TODO(), &goods, sq.IncrementInt{
+			Column: goodsCol.Sold,
+			Value: 1,
+			Max: sq.BoundColumn(goodsCol.Stock),
+		}, "UPDATE `goods` SET `sold` = `sold` + ? WHERE `id` = ? AND `sold` + ? <= `stock` LIMIT ?")
+		assert.NoError(t, err)
+		assert.Equal(t, i < 2, affected)
+	}
+	assert.Equal(t, uint64(2), goods.Sold)
+	{
+		affected, err := testDB.DecrementIntModel(context.TODO(), &goods, sq.DecrementInt{
+			Column: goodsCol.Stock,
+			Value: 3,
+			Min: sq.BoundValue(0),
 		})
 		assert.NoError(t, err)
-		assert.Equal(t, count, uint64(0))
+		assert.False(t, affected)
+		assert.Equal(t, uint64(2), goods.Stock)
 	}
 	{
-		result, err := testDB.Insert(context.TODO(), sq.QB{
-			Table: TableUser{},
-			Insert: []sq.Insert{
-				sq.Value(userCol.ID, sq.UUID()),
-				sq.Value(userCol.Name, "TestHardDelete"),
-				sq.Value(userCol.Age, 18),
-			},
-			CheckSQL:[]string{"INSERT INTO `user` (`id`,`name`,`age`) VALUES (?,?,?)"},
+		affected, err := testDB.DecrementIntModel(context.TODO(), &goods, sq.DecrementInt{
+			Column: goodsCol.Stock,
+			Value: 2,
+			Min: sq.BoundValue(0),
 		})
 		assert.NoError(t, err)
-		affected, err := result.RowsAffected()
+		assert.True(t, affected)
+		assert.Equal(t, uint64(0), goods.Stock)
+	}
+	{
+		affected, err := testDB.IncrementFloatModel(context.TODO(), &goods, sq.IncrementFloat{
+			Column: goodsCol.Price,
+			Value: 0.5,
+		})
 		assert.NoError(t, err)
-		assert.Equal(t, affected, int64(1))
+		assert.True(t, affected)
+		assert.Equal(t, 2.0, goods.Price)
 	}
 	{
-		count, err := testDB.Count(context.TODO(), sq.QB{
-			Table:             User{},
-			DisableSoftDelete: true,
-			Where:             sq.And(userCol.Name, sq.LikeLeft("TestHardDelete")),
-			CheckSQL:          []string{"SELECT COUNT(*) FROM `user` WHERE `name` LIKE ?"},
+		affected, err := testDB.DecrementFloatModel(context.TODO(), &goods, sq.DecrementFloat{
+			Column: goodsCol.Price,
+			Value: 1.5,
+			Min: sq.BoundValue(1),
 		})
 		assert.NoError(t, err)
-		assert.Equal(t, count, uint64(1))
+		assert.False(t, affected)
+		assert.Equal(t, 2.0, goods.Price)
 	}
 	{
-		result, err := testDB.HardDelete(context.TODO(), sq.QB{
-			Table: User{},
-			Where: sq.And(userCol.Name, sq.Like("TestHardDelete")),
-			CheckSQL:[]string{"DELETE FROM `user` WHERE `name` LIKE ?"},
+		_, err := testDB.IncrementFloatModel(context.TODO(), &goods, sq.IncrementFloat{
+			Column: goodsCol.Sold,
+			Value: 0.5,
+		})
+		assert.EqualError(t, err, "goclub/sql: can not increase uint64 field `sold` by float64")
+		_, err = testDB.DecrementFloatModel(context.TODO(), &goods, sq.DecrementFloat{
+			Column: goodsCol.Price,
+			Value: -1,
+			Min: sq.BoundValue(1),
+		})
+		assert.EqualError(t, err, "goclub/sql: IncrementModel DecrementModel Value must be positive")
+		_, err = testDB.IncrementIntModel(context.TODO(), &goods, sq.IncrementInt{
+			Column: goodsCol.Sold,
+			Value: 0,
+		})
+		assert.EqualError(t, err, "goclub/sql: IncrementModel DecrementModel Value must be positive")
+		// 内存中的 stock 为 0, uint64 字段无法写回 -1
+		_, err = testDB.DecrementIntModel(context.TODO(), &goods, sq.DecrementInt{
+			Column: goodsCol.Stock,
+			Value: 1,
+		})
+		assert.EqualError(t, err, "goclub/sql: IncrementModel DecrementModel uint64 field `stock` overflow")
+	}
+	{
+		// 递增递减会在同一条 UPDATE 中增加版本号, 之前查询的数据无法通过 UpdateModel 覆盖递增递减的结果
+		stale := VersionedGoods{}
+		has, err := testDB.QueryStruct(context.TODO(), &stale, sq.QB{
+			Where: sq.And("id", sq.Equal(goods.ID)),
 		})
 		assert.NoError(t, err)
-		affected, err := result.RowsAffected()
+		assert.True(t, has)
+		versioned := stale
+		affected, err := testDB.IncrementIntModel(context.TODO(), &versioned, sq.IncrementInt{
+			Column: goodsCol.Stock,
+			Value: 1,
+		}, "UPDATE `goods` SET `stock` = `stock` + ?,`version` = `version` + 1 WHERE `id` = ? LIMIT ?")
+		assert.NoError(t, err)
+		assert.True(t, affected)
+		assert.Equal(t, stale.Version.Version + 1, versioned.Version.Version)
+		_, err = testDB.UpdateModel(context.TODO(), &stale, []sq.Update{sq.Set(goodsCol.Stock, uint64(5))}, nil)
+		assert.Equal(t, sq.ErrStaleObject, err)
+		_, err = testDB.UpdateModel(context.TODO(), &versioned, []sq.Update{sq.Set(goodsCol.Stock, uint64(0))}, nil)
 		assert.NoError(t, err)
-		assert.Equal(t, affected, int64(1))
 	}
 	{
-		count, err := testDB.Count(context.TODO(), sq.QB{
-			Table:             User{},
-			DisableSoftDelete: true,
-			Where:             sq.And(userCol.Name, sq.LikeLeft("TestHardDelete")),
-			CheckSQL:          []string{"SELECT COUNT(*) FROM `user` WHERE `name` LIKE ?"},
+		result := Goods{}
+		has, err := testDB.QueryStruct(context.TODO(), &result, sq.QB{
+			Where: sq.And("id", sq.Equal(goods.ID)),
 		})
 		assert.NoError(t, err)
-		assert.Equal(t, count, uint64(0))
+		assert.True(t, has)
+		assert.Equal(t, uint64(0), result.Stock)
+		assert.Equal(t, uint64(2), result.Sold)
+		assert.Equal(t, 2.0, result.Price)
 	}
 }
-
-
-
-
-
-func (suite TestDBSuite) TestHardDeleteModel() {
+func (suite TestDBSuite) TestSaveModel() {
 	t := suite.T()
 	userCol := User{}.Column()
+	newID := IDUser(sq.UUID())
 	{
 		_, err := testDB.ClearTestData(context.TODO(), sq.QB{
 			Table: User{},
-			Where: sq.And(userCol.Name, sq.Like("TestHardDeleteModel")),
+			Where: sq.And(userCol.Name, sq.Like("TestSaveModel")),
 			CheckSQL:[]string{"DELETE FROM `user` WHERE `name` LIKE ?"},
 		})
 		assert.NoError(t, err)
-
+		err = testDB.InsertModel(context.TODO(), &User{ID: newID, Name: "TestSaveModel", Age: 18})
+		assert.NoError(t, err)
 	}
 	{
-		count, err := testDB.Count(context.TODO(), sq.QB{
-			Table:             User{},
+		user := TrackedUser{ID: newID}
+		_, err := testDB.SaveModel(context.TODO(), &user)
+		assert.EqualError(t, err, "goclub/sql: SaveModel(ctx, ptr) *sq_test.TrackedUser is not tracked, query it by QueryStruct or call sq.Track(ptr) first")
+	}
+	user := TrackedUser{}
+	{
+		has, err := testDB.QueryStruct(context.TODO(), &user, sq.QB{
+			Where: sq.And(userCol.ID, sq.Equal(newID)),
+		})
+		assert.NoError(t, err)
+		assert.True(t, has)
+	}
+	{
+		result, err := testDB.SaveModel(context.TODO(), &user)
+		assert.NoError(t, err)
+		affected, err := result.RowsAffected()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), affected)
+	}
+	{
+		updatedAt := user.UpdatedAt
+		user.Age = 20
+		result, err := testDB.SaveModel(context.TODO(), &user)
+		assert.NoError(t, err)
+		affected, err := result.RowsAffected()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), affected)
+		assert.True(t, user.UpdatedAt.After(updatedAt))
+	}
+	{
+		result := User{}
+		has, err := testDB.QueryStruct(context.TODO(), &result, sq.QB{
+			Where: sq.And(userCol.ID, sq.Equal(newID)),
+		})
+		assert.NoError(t, err)
+		assert.True(t, has)
+		assert.Equal(t, "TestSaveModel", result.Name)
+		assert.Equal(t, 20, result.Age)
+	}
+}
+func (suite TestDBSuite) TestHardDelete() {
+	t := suite.T()
+	userCol := User{}.Column()
+	{
+		_, err := testDB.ClearTestData(context.TODO(), sq.QB{
+			Table: User{},
+			Where: sq.And(userCol.Name, sq.Like("TestHardDelete")),
+			CheckSQL:[]string{"DELETE FROM `user` WHERE `name` LIKE ?"},
+		})
+		assert.NoError(t, err)
+
+	}
+	{
+		count, err := testDB.Count(context.TODO(), sq.QB{
+			Table:             User{},
+			DisableSoftDelete: true,
+			Where:             sq.And(userCol.Name, sq.LikeLeft("TestHardDelete")),
+			CheckSQL:          []string{"SELECT COUNT(*) FROM `user` WHERE `name` LIKE ?"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, count, uint64(0))
+	}
+	{
+		result, err := testDB.Insert(context.TODO(), sq.QB{
+			Table: TableUser{},
+			Insert: []sq.Insert{
+				sq.Value(userCol.ID, sq.UUID()),
+				sq.Value(userCol.Name, "TestHardDelete"),
+				sq.Value(userCol.Age, 18),
+			},
+			CheckSQL:[]string{"INSERT INTO `user` (`id`,`name`,`age`) VALUES (?,?,?)"},
+		})
+		assert.NoError(t, err)
+		affected, err := result.RowsAffected()
+		assert.NoError(t, err)
+		assert.Equal(t, affected, int64(1))
+	}
+	{
+		count, err := testDB.Count(context.TODO(), sq.QB{
+			Table:             User{},
+			DisableSoftDelete: true,
+			Where:             sq.And(userCol.Name, sq.LikeLeft("TestHardDelete")),
+			CheckSQL:          []string{"SELECT COUNT(*) FROM `user` WHERE `name` LIKE ?"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, count, uint64(1))
+	}
+	{
+		result, err := testDB.HardDelete(context.TODO(), sq.QB{
+			Table: User{},
+			Where: sq.And(userCol.Name, sq.Like("TestHardDelete")),
+			CheckSQL:[]string{"DELETE FROM `user` WHERE `name` LIKE ?"},
+		})
+		assert.NoError(t, err)
+		affected, err := result.RowsAffected()
+		assert.NoError(t, err)
+		assert.Equal(t, affected, int64(1))
+	}
+	{
+		count, err := testDB.Count(context.TODO(), sq.QB{
+			Table:             User{},
+			DisableSoftDelete: true,
+			Where:             sq.And(userCol.Name, sq.LikeLeft("TestHardDelete")),
+			CheckSQL:          []string{"SELECT COUNT(*) FROM `user` WHERE `name` LIKE ?"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, count, uint64(0))
+	}
+}
+
+
+
+
+
+func (suite TestDBSuite) TestHardDeleteModel() {
+	t := suite.T()
+	userCol := User{}.Column()
+	{
+		_, err := testDB.ClearTestData(context.TODO(), sq.QB{
+			Table: User{},
+			Where: sq.And(userCol.Name, sq.Like("TestHardDeleteModel")),
+			CheckSQL:[]string{"DELETE FROM `user` WHERE `name` LIKE ?"},
+		})
+		assert.NoError(t, err)
+
+	}
+	{
+		count, err := testDB.Count(context.TODO(), sq.QB{
+			Table:             User{},
 			DisableSoftDelete: true,
 			Where:             sq.And(userCol.Name, sq.LikeLeft("TestHardDeleteModel")),
 			CheckSQL:          []string{"SELECT COUNT(*) FROM `user` WHERE `name` LIKE ?"},
@@ -1028,6 +1576,482 @@ func (suite TestDBSuite) TestSoftDeleteModel() {
 	}
 }
 
+func (suite TestDBSuite) TestRestore() {
+	t := suite.T()
+	userCol := User{}.Column()
+	{
+		_, err := testDB.ClearTestData(context.TODO(), sq.QB{
+			Table: User{},
+			Where: sq.And(userCol.Name, sq.Like("TestRestore")),
+			CheckSQL:[]string{"DELETE FROM `user` WHERE `name` LIKE ?"},
+		})
+		assert.NoError(t, err)
+	}
+	newID := IDUser(sq.UUID())
+	{
+		err := testDB.InsertModel(context.TODO(), &User{ID: newID, Name: "TestRestore", Age: 18})
+		assert.NoError(t, err)
+		_, err = testDB.SoftDeleteModel(context.TODO(), &User{ID: newID})
+		assert.NoError(t, err)
+	}
+	countByMode := func(mode sq.SoftDeleteMode) uint64 {
+		count, err := testDB.Count(context.TODO(), sq.QB{
+			Table: User{},
+			Where: sq.And(userCol.ID, sq.Equal(newID)),
+			SoftDeleteMode: mode,
+		})
+		assert.NoError(t, err)
+		return count
+	}
+	assert.Equal(t, uint64(0), countByMode(sq.SoftDeleteDefault))
+	assert.Equal(t, uint64(1), countByMode(sq.WithTrashed))
+	assert.Equal(t, uint64(1), countByMode(sq.OnlyTrashed))
+	{
+		result, err := testDB.RestoreModel(context.TODO(), &User{ID: newID}, "UPDATE `user` SET `deleted_at` = NULL WHERE `id` = ? AND `deleted_at` IS NOT NULL LIMIT ?")
+		assert.NoError(t, err)
+		affected, err := result.RowsAffected()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), affected)
+	}
+	assert.Equal(t, uint64(1), countByMode(sq.SoftDeleteDefault))
+	assert.Equal(t, uint64(0), countByMode(sq.OnlyTrashed))
+	{
+		_, err := testDB.SoftDeleteModel(context.TODO(), &User{ID: newID})
+		assert.NoError(t, err)
+		result, err := testDB.Restore(context.TODO(), sq.QB{
+			Table: User{},
+			Where: sq.And(userCol.Name, sq.Equal("TestRestore")),
+		})
+		assert.NoError(t, err)
+		affected, err := result.RowsAffected()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), affected)
+	}
+	assert.Equal(t, uint64(1), countByMode(sq.SoftDeleteDefault))
+}
+
+func (suite TestDBSuite) TestScope() {
+	t := suite.T()
+	tenant1 := context.WithValue(context.TODO(), tenantKey{}, uint64(1))
+	tenant2 := context.WithValue(context.TODO(), tenantKey{}, uint64(2))
+	adminCtx := testDB.WithoutScopes(context.TODO(), "TestScope")
+	{
+		_, err := testDB.ClearTestData(adminCtx, sq.QB{
+			Table: TableNote{},
+			Where: sq.And("body", sq.Like("TestScope")),
+		})
+		assert.NoError(t, err)
+	}
+	note := Note{Body: "TestScope"}
+	{
+		err := testDB.InsertModel(tenant1, &note, "INSERT INTO `note` (`tenant_id`,`body`) VALUES (?,?)")
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(1), note.TenantID)
+		err = testDB.InsertModel(tenant1, &Note{TenantID: 2, Body: "TestScope"})
+		assert.EqualError(t, err, "goclub/sql: *sq_test.Note field `tenant_id` does not match scope")
+	}
+	{
+		count, err := testDB.Count(tenant2, sq.QB{
+			Table: TableNote{},
+			Where: sq.And("body", sq.Equal("TestScope")),
+			CheckSQL: []string{"SELECT COUNT(*) FROM `note` WHERE (`body` = ? AND `deleted_at` IS NULL) AND `tenant_id` = ?"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(0), count)
+	}
+	{
+		result, err := testDB.Update(tenant2, sq.QB{
+			Table: TableNote{},
+			Where: sq.And("id", sq.Equal(note.ID)),
+			Update: []sq.Update{sq.Set("body", "TestScope_changed")},
+		})
+		assert.NoError(t, err)
+		affected, err := result.RowsAffected()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), affected)
+	}
+	{
+		count, err := testDB.Count(tenant1, sq.QB{
+			Table: TableNote{},
+			Where: sq.And("body", sq.Equal("TestScope")),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(1), count)
+		count, err = testDB.Count(adminCtx, sq.QB{
+			Table: TableNote{},
+			Where: sq.And("body", sq.Equal("TestScope")),
+			CheckSQL: []string{"SELECT COUNT(*) FROM `note` WHERE `body` = ? AND `deleted_at` IS NULL"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(1), count)
+	}
+	{
+		// 子查询使用外层的 ctx
+		qb := sq.QB{
+			Table: TableNote{},
+			Where: sq.And("id", sq.SubQuery("IN", sq.QB{
+				Table: TableNote{},
+				Select: []sq.Column{"id"},
+				Where: sq.And("body", sq.Equal("TestScope")),
+			})),
+		}
+		count, err := testDB.Count(tenant1, qb)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(1), count)
+		count, err = testDB.Count(tenant2, qb)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(0), count)
+	}
+	{
+		// 主键冲突的数据属于其他租户时不会被修改
+		_, err := testDB.UpsertModel(tenant2, &Note{ID: note.ID, Body: "TestScope_changed"}, nil, "INSERT INTO `note` (`id`,`tenant_id`,`body`) VALUES (?,?,?) ON DUPLICATE KEY UPDATE `tenant_id` = CASE WHEN `note`.`tenant_id` = ? THEN VALUES(`tenant_id`) ELSE `note`.`tenant_id` END,`body` = CASE WHEN `note`.`tenant_id` = ? THEN VALUES(`body`) ELSE `note`.`body` END")
+		assert.NoError(t, err)
+		var queryNote Note
+		has, err := testDB.QueryStruct(tenant1, &queryNote, sq.QB{Where: sq.And("id", sq.Equal(note.ID))})
+		assert.NoError(t, err)
+		assert.True(t, has)
+		assert.Equal(t, "TestScope", queryNote.Body)
+		assert.Equal(t, uint64(1), queryNote.TenantID)
+		_, err = testDB.Insert(tenant1, sq.QB{
+			Table: TableNote{},
+			Insert: []sq.Insert{{Column: "body", Value: "TestScope"}},
+			Replace: true,
+		})
+		assert.EqualError(t, err, "goclub/sql: table note has scopes, can not use QB.Replace, use QB.OnDuplicateKeyUpdate")
+		_, err = testDB.Insert(tenant1, sq.QB{
+			Table: TableNote{},
+			Insert: []sq.Insert{{Column: "body", Value: "TestScope"}},
+			OnDuplicateKeyUpdate: []sq.Update{{Raw: sq.Raw{"`body` = CONCAT(`body`, ?)", []interface{}{"_changed"}}}},
+		})
+		assert.EqualError(t, err, "goclub/sql: table note has scopes, OnDuplicateKeyUpdate can not use Update.Raw, use sq.Set or sq.SetInsertValue")
+	}
+	{
+		// Join.Table 会在 ON 中增加作用域条件
+		_, err := testDB.Count(tenant2, sq.QB{
+			Table: User{},
+			SoftDeleteMode: sq.WithTrashed,
+			Join: []sq.Join{
+				{Type: sq.InnerJoin, Table: TableNote{}, On: "`note`.`body` = `user`.`name`"},
+			},
+			CheckSQL: []string{"SELECT COUNT(*) FROM `user` INNER JOIN `note` ON (`note`.`body` = `user`.`name`) AND `note`.`tenant_id` = ?"},
+		})
+		assert.NoError(t, err)
+		var notes []Note
+		err = testDB.QuerySlice(tenant1, &notes, sq.QB{
+			Raw: sq.Raw{"SELECT * FROM `note`", nil},
+		})
+		assert.EqualError(t, err, "goclub/sql: table note has scopes, QB.Raw can not add scope conditions, use QB.Where or db.WithoutScopes")
+	}
+	{
+		// ctx 中缺少作用域的值时返回错误
+		var notes []Note
+		err := testDB.QuerySlice(context.TODO(), &notes, sq.QB{})
+		assert.EqualError(t, err, "goclub/sql: table note scopes: missing tenant id")
+		_, err = testDB.Count(context.TODO(), sq.QB{
+			Table: User{},
+			Where: sq.And("id", sq.SubQuery("IN", sq.QB{
+				Table: TableNote{},
+				Select: []sq.Column{"body"},
+			})),
+		})
+		assert.EqualError(t, err, "goclub/sql: table note scopes: missing tenant id")
+		err = testDB.InsertModel(tenant1, &NoteStringTenant{Body: "TestScope"})
+		assert.EqualError(t, err, "goclub/sql: scope `tenant_id` value uint64 can not convert to *sq_test.NoteStringTenant field type string")
+	}
+}
+
+func (suite TestDBSuite) TestLifeCycle() {
+	t := suite.T()
+	log := LogHook{Message: "TestLifeCycle"}
+	{
+		err := testDB.InsertModel(context.TODO(), &log)
+		assert.NoError(t, err)
+	}
+	{
+		_, err := testDB.UpdateModel(context.TODO(), &log, []sq.Update{{Column: "message", Value: "TestLifeCycle_updated"}}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"BeforeUpdate", "AfterUpdate"}, log.Hooks)
+	}
+	{
+		queryLog := LogHook{}
+		has, err := testDB.QueryStruct(context.TODO(), &queryLog, sq.QB{
+			Where: sq.And("id", sq.Equal(log.ID)),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, true, has)
+		assert.Equal(t, []string{"AfterQuery"}, queryLog.Hooks)
+	}
+	{
+		var list []LogHook
+		err := testDB.QuerySlice(context.TODO(), &list, sq.QB{
+			Where: sq.And("id", sq.Equal(log.ID)),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(list))
+		assert.Equal(t, []string{"AfterQuery"}, list[0].Hooks)
+	}
+	{
+		log.Hooks = nil
+		_, err := testDB.SoftDeleteModel(context.TODO(), &log)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"BeforeSoftDelete", "AfterDelete"}, log.Hooks)
+	}
+	{
+		log.Hooks = nil
+		_, err := testDB.HardDeleteModel(context.TODO(), &log)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"BeforeDelete", "AfterDelete"}, log.Hooks)
+	}
+}
+func (suite TestDBSuite) TestInterceptor() {
+	t := suite.T()
+	db, dbClose, err := sq.Open("mysql", testDataSourceName) ; if err != nil {
+		panic(err)
+	}
+	defer dbClose()
+	type faultKey struct{}
+	var statements []sq.Statement
+	var rowsAffected []int64
+	db.Use(func(ctx context.Context, statement sq.Statement, query string, values []interface{}, next sq.InterceptorNext) (sql.Result, error) {
+		statements = append(statements, statement)
+		result, err := next(ctx, query, values)
+		if err == nil && result != nil {
+			affected, _ := result.RowsAffected()
+			rowsAffected = append(rowsAffected, affected)
+		}
+		return result, err
+	}, func(ctx context.Context, statement sq.Statement, query string, values []interface{}, next sq.InterceptorNext) (sql.Result, error) {
+		if ctx.Value(faultKey{}) != nil {
+			return nil, errors.New("fault injection")
+		}
+		return next(ctx, query, values)
+	})
+	log := Log{Message: "TestInterceptor"}
+	err = db.InsertModel(context.TODO(), &log)
+	assert.NoError(t, err)
+	queryLog := Log{}
+	has, err := db.QueryStruct(context.TODO(), &queryLog, sq.QB{
+		Where: sq.And("id", sq.Equal(log.ID)),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, true, has)
+	assert.Equal(t, []sq.Statement{"INSERT", "SELECT"}, statements)
+	assert.Equal(t, []int64{1, 1}, rowsAffected)
+	{
+		faultCtx := context.WithValue(context.TODO(), faultKey{}, true)
+		_, err := db.QueryStruct(faultCtx, &queryLog, sq.QB{
+			Where: sq.And("id", sq.Equal(log.ID)),
+		})
+		assert.EqualError(t, err, "fault injection")
+	}
+	statements = nil
+	_, err = db.Transaction(context.TODO(), func(tx *sq.Transaction) sq.TxResult {
+		_, err := tx.HardDeleteModel(context.TODO(), &log) ; if err != nil {
+			return tx.RollbackWithError(err)
+		}
+		return tx.Commit()
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []sq.Statement{"DELETE"}, statements)
+}
+type testLogger struct {
+	events []sq.LogEvent
+}
+func (logger *testLogger) Log(ctx context.Context, event sq.LogEvent) {
+	logger.events = append(logger.events, event)
+}
+func (suite TestDBSuite) TestLogger() {
+	t := suite.T()
+	db, dbClose, err := sq.Open("mysql", testDataSourceName) ; if err != nil {
+		panic(err)
+	}
+	defer dbClose()
+	logger := &testLogger{}
+	db.SetLogger(logger)
+	log := Log{Message: "TestLogger"}
+	err = db.InsertModel(context.TODO(), &log)
+	assert.NoError(t, err)
+	var list []Log
+	err = db.QuerySlice(context.TODO(), &list, sq.QB{
+		Where: sq.And("id", sq.Equal(sq.Redact(log.ID))),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, 2, len(logger.events))
+	insertEvent := logger.events[0]
+	assert.Equal(t, sq.Statement("INSERT"), insertEvent.Statement)
+	assert.Equal(t, int64(1), insertEvent.Rows)
+	assert.Equal(t, "", insertEvent.TxID)
+	assert.Contains(t, insertEvent.Caller, "db_test.go:")
+	selectEvent := logger.events[1]
+	assert.Equal(t, sq.Statement("SELECT"), selectEvent.Statement)
+	assert.Equal(t, []interface{}{"[redacted]"}, selectEvent.Values)
+	assert.Equal(t, int64(1), selectEvent.Rows)
+
+	logger.events = nil
+	_, err = db.Transaction(context.TODO(), func(tx *sq.Transaction) sq.TxResult {
+		_, err := tx.Exec(context.TODO(), "SELECT * FROM `not_exist_table`", nil)
+		return tx.RollbackWithError(err)
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, len(logger.events))
+	assert.Error(t, logger.events[0].Err)
+	assert.NotEqual(t, "", logger.events[0].TxID)
+}
+func (suite TestDBSuite) TestSlowQuery() {
+	t := suite.T()
+	db, dbClose, err := sq.Open("mysql", testDataSourceName) ; if err != nil {
+		panic(err)
+	}
+	defer dbClose()
+	// MySQL 的 EXPLAIN 和 handle 在单独的 goroutine 中执行
+	events := make(chan sq.SlowQueryEvent, 10)
+	db.SetSlowQuery(time.Nanosecond, func(ctx context.Context, event sq.SlowQueryEvent) {
+		events <- event
+	})
+	var list []Log
+	err = db.QuerySlice(context.TODO(), &list, sq.QB{
+		Where: sq.And("message", sq.Equal("TestSlowQuery")),
+		OrderBy: []sq.OrderBy{{"message", sq.DESC}},
+	})
+	assert.NoError(t, err)
+	var event sq.SlowQueryEvent
+	select {
+	case event = <-events:
+	case <-time.After(time.Second * 5):
+		t.Fatal("slow query handle not called")
+	}
+	assert.NoError(t, event.ExplainErr)
+	assert.Equal(t, sq.Statement("SELECT"), event.Statement)
+	assert.Contains(t, event.Plan, "query_block")
+	assert.Equal(t, true, event.FullTableScan)
+	assert.Contains(t, event.Caller, "db_test.go:")
+
+	db.SetSlowQuery(0, nil)
+	err = db.QuerySlice(context.TODO(), &list, sq.QB{})
+	assert.NoError(t, err)
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, 0, len(events))
+}
+func (suite TestDBSuite) TestOpenCluster() {
+	t := suite.T()
+	_, _, err := sq.OpenCluster("mysql", testDataSourceName, nil)
+	assert.EqualError(t, err, "goclub/sql: OpenCluster(driverName, primaryDataSourceName, replicas) replicas can not be empty")
+	db, dbClose, err := sq.OpenCluster("mysql", testDataSourceName, []sq.Replica{
+		{DataSourceName: testDataSourceName},
+		{DataSourceName: testDataSourceName, Weight: 2},
+	}) ; if err != nil {
+		panic(err)
+	}
+	defer dbClose()
+	assert.NoError(t, db.Ping())
+	log := Log{Message: "TestOpenCluster"}
+	err = db.InsertModel(context.TODO(), &log)
+	assert.NoError(t, err)
+	qb := sq.QB{
+		Where: sq.And("id", sq.Equal(log.ID)),
+	}
+	for i:=0;i<3;i++ {
+		queryLog := Log{}
+		has, err := db.QueryStruct(context.TODO(), &queryLog, qb)
+		assert.NoError(t, err)
+		assert.Equal(t, true, has)
+	}
+	{
+		queryLog := Log{}
+		has, err := db.QueryStruct(sq.ForcePrimary(context.TODO()), &queryLog, qb)
+		assert.NoError(t, err)
+		assert.Equal(t, true, has)
+	}
+	_, err = db.Transaction(context.TODO(), func(tx *sq.Transaction) sq.TxResult {
+		lockQB := qb
+		lockQB.Lock = sq.FORUPDATE
+		queryLog := Log{}
+		_, err := tx.QueryStruct(context.TODO(), &queryLog, lockQB) ; if err != nil {
+			return tx.RollbackWithError(err)
+		}
+		return tx.Commit()
+	})
+	assert.NoError(t, err)
+}
+func (suite TestDBSuite) TestTransactionRetry() {
+	t := suite.T()
+	policy := sq.RetryPolicy{
+		MaxAttempts: 3,
+		Backoff: func(attempt int) time.Duration { return 0 },
+	}
+	{
+		runTimes := 0
+		isRollback, attempts, err := testDB.TransactionRetry(context.TODO(), func(tx *sq.Transaction) sq.TxResult {
+			runTimes++
+			if runTimes == 1 {
+				return tx.RollbackWithError(&mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"})
+			}
+			return tx.Commit()
+		}, nil, policy)
+		assert.NoError(t, err)
+		assert.Equal(t, false, isRollback)
+		assert.Equal(t, 2, attempts)
+	}
+	{
+		isRollback, attempts, err := testDB.TransactionRetry(context.TODO(), func(tx *sq.Transaction) sq.TxResult {
+			return tx.RollbackWithError(errors.WithStack(&mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}))
+		}, nil, policy)
+		assert.Error(t, err)
+		assert.Equal(t, true, isRollback)
+		assert.Equal(t, 3, attempts)
+	}
+	{
+		_, attempts, err := testDB.TransactionRetry(context.TODO(), func(tx *sq.Transaction) sq.TxResult {
+			return tx.RollbackWithError(errors.New("abc"))
+		}, nil, policy)
+		assert.EqualError(t, err, "abc")
+		assert.Equal(t, 1, attempts)
+	}
+}
+func (suite TestDBSuite) TestErrorKind() {
+	t := suite.T()
+	ctx := context.TODO()
+	{
+		_, err := testDB.ClearTestData(ctx, sq.QB{
+			Table: User{},
+			Where: sq.And("name", sq.Equal("TestErrorKind")),
+		})
+		assert.NoError(t, err)
+	}
+	{
+		user := User{Name: "TestErrorKind"}
+		assert.NoError(t, testDB.InsertModel(ctx, &user))
+		err := testDB.InsertModel(ctx, &User{ID: user.ID, Name: "TestErrorKind"})
+		assert.True(t, sq.IsDuplicateKey(err))
+		assert.False(t, sq.IsDeadlock(err))
+		var sqlErr *sq.Error
+		assert.True(t, errors.As(err, &sqlErr))
+		assert.Equal(t, sq.ErrorKindDuplicateKey, sqlErr.Kind)
+		assert.Equal(t, "INSERT INTO `user` (`id`,`name`,`age`,`created_at`,`updated_at`) VALUES (?,?,?,?,?)", sqlErr.Query)
+		assert.Equal(t, user.ID, sqlErr.Values[0])
+		var mysqlErr *mysql.MySQLError
+		assert.True(t, errors.As(err, &mysqlErr))
+		assert.Equal(t, uint16(1062), mysqlErr.Number)
+	}
+	{
+		// 未识别分类的错误不会被包装
+		_, err := testDB.Exec(ctx, "SELECT * FROM `TestErrorKind_not_exist`", nil)
+		assert.Error(t, err)
+		var sqlErr *sq.Error
+		assert.False(t, errors.As(err, &sqlErr))
+		assert.False(t, sq.IsDuplicateKey(err))
+	}
+	{
+		// 没有经过 goclub/sql 执行的驱动错误也可以识别
+		assert.True(t, sq.IsDeadlock(errors.WithStack(&mysql.MySQLError{Number: 1213})))
+		assert.True(t, sq.IsLockWaitTimeout(&mysql.MySQLError{Number: 1205}))
+		assert.True(t, sq.IsForeignKeyViolation(&mysql.MySQLError{Number: 1452}))
+		assert.True(t, sq.IsDataTooLong(&mysql.MySQLError{Number: 1406}))
+		assert.True(t, sq.IsConnectionLost(mysql.ErrInvalidConn))
+		assert.False(t, sq.IsDuplicateKey(nil))
+	}
+}
 func (suite TestDBSuite) TestExecQB() {
 	t := suite.T()
 	userCol := User{}.Column()
@@ -1166,6 +2190,400 @@ func (suite TestDBSuite) TestTransaction() {
 
 
 
+func (suite TestDBSuite) TestSavepoint() {
+	t := suite.T()
+	userCol := User{}.Column()
+	{
+		_, err := testDB.ClearTestData(context.TODO(), sq.QB{
+			Table: User{},
+			Where: sq.And(userCol.Name, sq.Like("TestSavepoint")),
+			CheckSQL:[]string{"DELETE FROM `user` WHERE `name` LIKE ?"},
+		})
+		assert.NoError(t, err)
+	}
+	{
+		isRollback, err := testDB.Transaction(context.TODO(), func(tx *sq.Transaction) sq.TxResult {
+			err := tx.InsertModel(context.TODO(), &User{Name:"TestSavepoint_outer"})
+			assert.NoError(t, err)
+			isRollback, err := tx.Transaction(context.TODO(), func(tx *sq.Transaction) sq.TxResult {
+				err := tx.InsertModel(context.TODO(), &User{Name:"TestSavepoint_rollback"})
+				assert.NoError(t, err)
+				return tx.RollbackWithError(errors.New("custom error"))
+			})
+			assert.True(t, isRollback)
+			assert.EqualError(t, err, "custom error")
+			isRollback, err = tx.Transaction(context.TODO(), func(tx *sq.Transaction) sq.TxResult {
+				err := tx.InsertModel(context.TODO(), &User{Name:"TestSavepoint_commit"})
+				assert.NoError(t, err)
+				return tx.Commit()
+			})
+			assert.False(t, isRollback)
+			assert.NoError(t, err)
+			return tx.Commit()
+		})
+		assert.False(t, isRollback)
+		assert.NoError(t, err)
+	}
+	for name, expected := range map[string]bool{
+		"TestSavepoint_outer": true,
+		"TestSavepoint_rollback": false,
+		"TestSavepoint_commit": true,
+	} {
+		has, err := testDB.Has(context.TODO(), sq.QB{
+			Table:User{},
+			Where: sq.And("name", sq.Equal(name)),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, expected, has, name)
+	}
+}
+func (suite TestDBSuite) TestTransactionCallback() {
+	t := suite.T()
+	{
+		var events []string
+		isRollback, err := testDB.Transaction(context.TODO(), func(tx *sq.Transaction) sq.TxResult {
+			tx.AfterCommit(func(ctx context.Context) { events = append(events, "commit") })
+			tx.AfterCommit(func(ctx context.Context) { panic("callback panic") })
+			tx.AfterRollback(func(ctx context.Context, err error) { events = append(events, "rollback") })
+			_, err := tx.Transaction(context.TODO(), func(tx *sq.Transaction) sq.TxResult {
+				tx.AfterCommit(func(ctx context.Context) { events = append(events, "savepoint_1 commit") })
+				tx.AfterRollback(func(ctx context.Context, err error) { events = append(events, "savepoint_1 rollback " + err.Error()) })
+				return tx.RollbackWithError(errors.New("custom error"))
+			})
+			assert.EqualError(t, err, "custom error")
+			_, err = tx.Transaction(context.TODO(), func(tx *sq.Transaction) sq.TxResult {
+				tx.AfterCommit(func(ctx context.Context) { events = append(events, "savepoint_2 commit") })
+				return tx.Commit()
+			})
+			assert.NoError(t, err)
+			return tx.Commit()
+		})
+		assert.False(t, isRollback)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"savepoint_1 rollback custom error", "commit", "savepoint_2 commit"}, events)
+	}
+	{
+		var events []string
+		isRollback, err := testDB.Transaction(context.TODO(), func(tx *sq.Transaction) sq.TxResult {
+			tx.AfterCommit(func(ctx context.Context) { events = append(events, "commit") })
+			_, err := tx.Transaction(context.TODO(), func(tx *sq.Transaction) sq.TxResult {
+				tx.AfterRollback(func(ctx context.Context, err error) { events = append(events, "savepoint rollback " + err.Error()) })
+				return tx.Commit()
+			})
+			assert.NoError(t, err)
+			return tx.RollbackWithError(errors.New("custom error"))
+		})
+		assert.True(t, isRollback)
+		assert.EqualError(t, err, "custom error")
+		assert.Equal(t, []string{"savepoint rollback custom error"}, events)
+	}
+}
+func (suite TestDBSuite) TestTransactionPanic() {
+	t := suite.T()
+	userCol := User{}.Column()
+	{
+		_, err := testDB.ClearTestData(context.TODO(), sq.QB{
+			Table: User{},
+			Where: sq.And(userCol.Name, sq.Like("TestTransactionPanic")),
+			CheckSQL:[]string{"DELETE FROM `user` WHERE `name` LIKE ?"},
+		})
+		assert.NoError(t, err)
+	}
+	assert.PanicsWithValue(t, "custom panic", func() {
+		_, _ = testDB.Transaction(context.TODO(), func(tx *sq.Transaction) sq.TxResult {
+			err := tx.InsertModel(context.TODO(), &User{Name:"TestTransactionPanic_1"})
+			assert.NoError(t, err)
+			panic("custom panic")
+		})
+	})
+	{
+		testDB.SetTxPanicAsError(true)
+		isRollback, err := testDB.Transaction(context.TODO(), func(tx *sq.Transaction) sq.TxResult {
+			err := tx.InsertModel(context.TODO(), &User{Name:"TestTransactionPanic_2"})
+			assert.NoError(t, err)
+			panic("custom panic")
+		})
+		testDB.SetTxPanicAsError(false)
+		assert.True(t, isRollback)
+		var panicErr *sq.TxPanicError
+		assert.True(t, errors.As(err, &panicErr))
+		assert.Equal(t, "custom panic", panicErr.Value)
+	}
+	{
+		ctx, cancel := context.WithCancel(context.TODO())
+		isRollback, err := testDB.Transaction(ctx, func(tx *sq.Transaction) sq.TxResult {
+			err := tx.InsertModel(ctx, &User{Name:"TestTransactionPanic_3"})
+			assert.NoError(t, err)
+			cancel()
+			return tx.Commit()
+		})
+		assert.True(t, isRollback)
+		assert.Equal(t, context.Canceled, err)
+	}
+	{
+		count, err := testDB.Count(context.TODO(), sq.QB{
+			Table: User{},
+			Where: sq.And(userCol.Name, sq.Like("TestTransactionPanic")),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(0), count)
+	}
+}
+func (suite TestDBSuite) TestFrom() {
+	t := suite.T()
+	userCol := User{}.Column()
+	{
+		_, err := testDB.ClearTestData(context.TODO(), sq.QB{
+			Table: User{},
+			Where: sq.And(userCol.Name, sq.Like("TestFrom")),
+			CheckSQL:[]string{"DELETE FROM `user` WHERE `name` LIKE ?"},
+		})
+		assert.NoError(t, err)
+	}
+	createUser := func(ctx context.Context, name string) error {
+		return testDB.From(ctx).InsertModel(ctx, &User{Name: name})
+	}
+	assert.Equal(t, testDB, testDB.From(context.TODO()))
+	{
+		err := createUser(context.TODO(), "TestFrom_db")
+		assert.NoError(t, err)
+	}
+	{
+		isRollback, err := testDB.Transaction(context.TODO(), func(tx *sq.Transaction) sq.TxResult {
+			ctx := sq.WithTx(context.TODO(), tx)
+			assert.Equal(t, tx, testDB.From(ctx))
+			err := createUser(ctx, "TestFrom_tx") ; if err != nil {
+				return tx.RollbackWithError(err)
+			}
+			return tx.Rollback()
+		})
+		assert.True(t, isRollback)
+		assert.NoError(t, err)
+	}
+	for name, expected := range map[string]bool{
+		"TestFrom_db": true,
+		"TestFrom_tx": false,
+	} {
+		has, err := testDB.Has(context.TODO(), sq.QB{
+			Table:User{},
+			Where: sq.And("name", sq.Equal(name)),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, expected, has, name)
+	}
+}
+func (suite TestDBSuite) TestPreload() {
+	t := suite.T()
+	ctx := context.TODO()
+	userCol := User{}.Column()
+	{
+		_, err := testDB.ClearTestData(ctx, sq.QB{
+			Table: User{},
+			Where: sq.And(userCol.Name, sq.LikeLeft("TestPreload")),
+		})
+		assert.NoError(t, err)
+		_, err = testDB.ClearTestData(ctx, sq.QB{
+			Table: UserAddress{},
+			Where: sq.And("address", sq.LikeLeft("TestPreload")),
+		})
+		assert.NoError(t, err)
+	}
+	var idList []IDUser
+	for i:=0;i<3;i++ {
+		user := User{Name: "TestPreload_" + strconv.Itoa(i)}
+		assert.NoError(t, testDB.InsertModel(ctx, &user))
+		idList = append(idList, user.ID)
+	}
+	// user_address 的主键是 user_id, TestPreload_2 没有地址
+	for i:=0;i<2;i++ {
+		_, err := testDB.Insert(ctx, sq.QB{
+			Table: UserAddress{},
+			Insert: []sq.Insert{
+				sq.Value("user_id", idList[i]),
+				sq.Value("address", "TestPreload_address_" + strconv.Itoa(i)),
+			},
+		})
+		assert.NoError(t, err)
+	}
+	{
+		var list []UserWithAddresses
+		err := testDB.QuerySlice(ctx, &list, sq.QB{
+			Where: sq.And(userCol.Name, sq.LikeLeft("TestPreload")),
+			OrderBy: []sq.OrderBy{{userCol.Name, sq.ASC}},
+			Preload: []sq.Preload{
+				{
+					Field: "Addresses",
+					QB: sq.QB{
+						OrderBy: []sq.OrderBy{{"address", sq.DESC}},
+						CheckSQL: []string{"SELECT `user_id`, `address` FROM `user_address` WHERE `user_id` IN (?, ?, ?) AND `deleted_at` IS NULL ORDER BY `address` DESC"},
+						Preload: []sq.Preload{
+							{
+								Field: "User",
+								QB: sq.QB{
+									CheckSQL: []string{"SELECT `id`, `name`, `age`, `created_at`, `updated_at` FROM `user` WHERE `id` IN (?, ?) AND `deleted_at` IS NULL"},
+								},
+							},
+						},
+					},
+				},
+			},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, len(list))
+		assert.Equal(t, 1, len(list[0].Addresses))
+		assert.Equal(t, "TestPreload_address_0", list[0].Addresses[0].Address)
+		assert.Equal(t, "TestPreload_0", list[0].Addresses[0].User.Name)
+		assert.Equal(t, 1, len(list[1].Addresses))
+		assert.Equal(t, "TestPreload_address_1", list[1].Addresses[0].Address)
+		assert.Equal(t, "TestPreload_1", list[1].Addresses[0].User.Name)
+		assert.Equal(t, []UserAddressWithUser{}, list[2].Addresses)
+	}
+	{
+		user := UserWithAddresses{}
+		has, err := testDB.QueryStruct(ctx, &user, sq.QB{
+			Where: sq.And(userCol.ID, sq.Equal(idList[0])),
+			Preload: []sq.Preload{{Field: "Addresses"}},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, true, has)
+		assert.Equal(t, 1, len(user.Addresses))
+		assert.Equal(t, "TestPreload_address_0", user.Addresses[0].Address)
+		assert.Nil(t, user.Addresses[0].User)
+	}
+	{
+		user := User{}
+		_, err := testDB.QueryStruct(ctx, &user, sq.QB{
+			Where: sq.And(userCol.ID, sq.Equal(idList[0])),
+			Preload: []sq.Preload{{Field: "Addresses"}},
+		})
+		assert.EqualError(t, err, "goclub/sql: QB.Preload sq_test.User must implements sq.Associationer")
+	}
+}
+func (suite TestDBSuite) TestSharding() {
+	t := suite.T()
+	ctx := context.TODO()
+	sharding := sq.NewSharding(testDB, testDB)
+	{
+		_, err := sharding.HardDelete(ctx, sq.QB{
+			Table: TableOrder{},
+			ShardFanOut: true,
+			Where: sq.And("amount", sq.GtOrEqualInt(0)),
+		})
+		assert.NoError(t, err)
+	}
+	// user_id 0 ~ 3 分别在 order_00 ~ order_03
+	for userID := uint64(0); userID < 4; userID++ {
+		order := Order{UserID: userID, Amount: int(userID) * 10}
+		assert.NoError(t, sharding.InsertModel(ctx, &order))
+		assert.NotEqual(t, uint64(0), order.ID)
+	}
+	{
+		// InsertMultiple 按分片拆分, user_id 1 和 5 都在 order_01
+		result, err := sharding.Insert(ctx, sq.QB{
+			Table: TableOrder{},
+			InsertMultiple: sq.InsertMultiple{
+				Column: []sq.Column{"user_id", "amount"},
+				Values: [][]interface{}{{uint64(1), 11}, {uint64(2), 21}, {uint64(5), 51}},
+			},
+		})
+		assert.NoError(t, err)
+		affected, err := result.RowsAffected()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), affected)
+		count, err := testDB.Count(ctx, sq.QB{
+			Table: TableOrder{},
+			Where: sq.And("user_id", sq.In([]uint64{1, 5})),
+			CheckSQL: []string{"SELECT COUNT(*) FROM `order_01` WHERE `user_id` IN (?, ?)"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(3), count)
+	}
+	{
+		// 在所有分片中查询, 合并后排序分页
+		var orders []Order
+		err := sharding.QuerySlice(ctx, &orders, sq.QB{
+			ShardFanOut: true,
+			OrderBy: []sq.OrderBy{{"amount", sq.DESC}},
+			Offset: 1,
+			Limit: 3,
+		})
+		assert.NoError(t, err)
+		var amounts []int
+		for _, order := range orders {
+			amounts = append(amounts, order.Amount)
+		}
+		assert.Equal(t, []int{30, 21, 20}, amounts)
+		count, err := sharding.Count(ctx, sq.QB{Table: TableOrder{}, ShardFanOut: true})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(7), count)
+		_, err = sharding.Count(ctx, sq.QB{Table: TableOrder{}})
+		assert.Equal(t, sq.ErrMissingShardKey, err)
+	}
+	{
+		order := Order{}
+		has, err := sharding.QueryStruct(ctx, &order, sq.QB{
+			Where: sq.And("user_id", sq.Equal(uint64(3))),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, true, has)
+		assert.Equal(t, 30, order.Amount)
+		result, err := sharding.Update(ctx, sq.QB{
+			Table: TableOrder{},
+			ShardFanOut: true,
+			Where: sq.And("amount", sq.GtOrEqualInt(20)),
+			Update: []sq.Update{{Raw: sq.Raw{"`amount` = `amount` + 1", nil}}},
+		})
+		assert.NoError(t, err)
+		affected, err := result.RowsAffected()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(4), affected)
+		// 查询多个分片时合并后排序
+		has, err = sharding.QueryStruct(ctx, &order, sq.QB{
+			ShardFanOut: true,
+			OrderBy: []sq.OrderBy{{"amount", sq.DESC}},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, true, has)
+		assert.Equal(t, 52, order.Amount)
+		_, err = sharding.Count(ctx, sq.QB{
+			Table: TableOrder{},
+			ShardFanOut: true,
+			GroupBy: []sq.Column{"user_id"},
+		})
+		assert.EqualError(t, err, "goclub/sql: Sharding.Count can not use QB.GroupBy or QB.UnionTable on multiple shards")
+	}
+	// 无法确定唯一分片时通过 Database 执行返回错误
+	{
+		var orders []Order
+		err := testDB.QuerySlice(ctx, &orders, sq.QB{})
+		assert.Equal(t, sq.ErrMissingShardKey, err)
+		err = testDB.QuerySlice(ctx, &orders, sq.QB{
+			Where: sq.And("user_id", sq.In([]uint64{1, 2})),
+		})
+		assert.EqualError(t, err, "goclub/sql: order shard key matched multiple shards, use Sharding")
+		// Database 只能执行 Shard{Database: 0} 的分片
+		err = testDB.QuerySlice(ctx, &orders, sq.QB{
+			Where: sq.And("user_id", sq.Equal(uint64(2))),
+		})
+		assert.EqualError(t, err, "goclub/sql: order_02 is in shard database 1 but executed on shard database 0, use Sharding")
+		err = testDB.QuerySlice(ctx, &orders, sq.QB{
+			Where: sq.And("user_id", sq.Equal(1)),
+		})
+		assert.EqualError(t, err, "order user_id must be uint64")
+		err = testDB.QuerySlice(ctx, &orders, sq.QB{
+			Where: sq.And("user_id", sq.SubQuery("IN", sq.QB{
+				Table: User{},
+				Select: []sq.Column{"age"},
+			})),
+		})
+		assert.Equal(t, sq.ErrMissingShardKey, err)
+		_, err = testDB.HardDelete(ctx, sq.QB{
+			Table: TableOrder{},
+			Where: sq.And("amount", sq.Equal(0)),
+		})
+		assert.Equal(t, sq.ErrMissingShardKey, err)
+	}
+}
 func (suite TestDBSuite) TestQueryRelation() {
 	t := suite.T()
 	userCol := User{}.Column()
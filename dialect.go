@@ -0,0 +1,239 @@
+package sq
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Dialect 描述不同数据库之间的 SQL 语法差异
+// QB.SQL() 内部统一使用 MySQL 风格生成 SQL（反引号包裹字段，? 作为占位符），最后通过 Dialect 转换为目标数据库的 SQL
+type Dialect interface {
+	// 方言名称 mysql postgres sqlite3
+	Name() string
+	// 包裹单个标识符 name => `name` 或 "name"
+	QuoteIdentifier(name string) string
+	// 包裹 SELECT 中的别名 AS 'user.id' 或 AS "user.id"
+	QuoteAlias(alias string) string
+	// 第 index 个占位符，index 从 1 开始
+	Placeholder(index int) string
+	// 生成 LIMIT OFFSET 语句，limit 和 offset 为 0 时表示不存在
+	LimitOffset(statement Statement, limit int, offset int) Raw
+	// 生成 SELECT 的锁语句，不支持行锁的数据库 panic 而不是忽略
+	Lock(lock SelectLock) string
+	// 生成 JOIN 语句，不支持的 JOIN 类型 panic
+	Join(joinType JoinType) string
+	// 单条 SQL 允许的最大占位符数量
+	MaxPlaceholders() int
+	// INSERT INTO, INSERT IGNORE INTO, REPLACE INTO
+	InsertInto(ignore bool, replace bool) string
+	// 插入冲突时的处理语句, sets 为空且 ignore 为 true 时表示忽略冲突
+	OnConflict(ignore bool, conflictColumns []Column, sets string) string
+	// 引用插入时的值 VALUES(`name`)
+	InsertValue(wrappedColumn string) string
+	// 是否支持 INSERT ... RETURNING
+	SupportReturning() bool
+}
+
+type MySQLDialect struct{}
+func (MySQLDialect) Name() string { return "mysql" }
+func (MySQLDialect) QuoteIdentifier(name string) string { return "`" + name + "`" }
+func (MySQLDialect) QuoteAlias(alias string) string { return "'" + alias + "'" }
+func (MySQLDialect) Placeholder(index int) string { return sqlPlaceholder }
+func (MySQLDialect) LimitOffset(statement Statement, limit int, offset int) (raw Raw) {
+	return limitOffset(limit, offset)
+}
+func (MySQLDialect) Lock(lock SelectLock) string { return lock.String() }
+// mysql 不支持 FULL OUTER JOIN, 需要使用 LEFT JOIN 和 RIGHT JOIN 的 UNION 代替
+func (MySQLDialect) Join(joinType JoinType) string {
+	if joinType == FullOuterJoin {
+		panic(errors.New("goclub/sql: mysql not support FULL OUTER JOIN, use UNION of LEFT JOIN and RIGHT JOIN"))
+	}
+	return joinType.String()
+}
+func (MySQLDialect) MaxPlaceholders() int { return 65535 }
+func (MySQLDialect) InsertInto(ignore bool, replace bool) string {
+	if replace {
+		return "REPLACE INTO"
+	}
+	if ignore {
+		return "INSERT IGNORE INTO"
+	}
+	return "INSERT INTO"
+}
+func (MySQLDialect) OnConflict(ignore bool, conflictColumns []Column, sets string) string {
+	if sets == "" {
+		return ""
+	}
+	return "ON DUPLICATE KEY UPDATE " + sets
+}
+func (MySQLDialect) InsertValue(wrappedColumn string) string { return "VALUES(" + wrappedColumn + ")" }
+func (MySQLDialect) SupportReturning() bool { return false }
+
+type PostgreSQLDialect struct{}
+func (PostgreSQLDialect) Name() string { return "postgres" }
+func (PostgreSQLDialect) QuoteIdentifier(name string) string { return `"` + name + `"` }
+func (PostgreSQLDialect) QuoteAlias(alias string) string { return `"` + alias + `"` }
+func (PostgreSQLDialect) Placeholder(index int) string { return "$" + strconv.Itoa(index) }
+// postgres 的 UPDATE DELETE 不支持 LIMIT, 设置了 QB.Limit 时 panic 而不是忽略, 避免修改所有匹配的数据
+func (PostgreSQLDialect) LimitOffset(statement Statement, limit int, offset int) (raw Raw) {
+	if statement != statement.Enum().Select {
+		mustNoLimit("postgres", statement, limit, offset)
+		return
+	}
+	return limitOffset(limit, offset)
+}
+func (PostgreSQLDialect) Lock(lock SelectLock) string { return lock.String() }
+func (PostgreSQLDialect) Join(joinType JoinType) string { return joinType.String() }
+func (PostgreSQLDialect) MaxPlaceholders() int { return 65535 }
+func (PostgreSQLDialect) InsertInto(ignore bool, replace bool) string {
+	if replace {
+		panic(errors.New("goclub/sql: postgres not support REPLACE INTO, use QB.OnDuplicateKeyUpdate"))
+	}
+	return "INSERT INTO"
+}
+func (PostgreSQLDialect) OnConflict(ignore bool, conflictColumns []Column, sets string) string {
+	return onConflict(ignore, conflictColumns, sets)
+}
+func (PostgreSQLDialect) InsertValue(wrappedColumn string) string { return "EXCLUDED." + wrappedColumn }
+func (PostgreSQLDialect) SupportReturning() bool { return true }
+
+type SQLiteDialect struct{}
+func (SQLiteDialect) Name() string { return "sqlite3" }
+func (SQLiteDialect) QuoteIdentifier(name string) string { return `"` + name + `"` }
+func (SQLiteDialect) QuoteAlias(alias string) string { return `"` + alias + `"` }
+func (SQLiteDialect) Placeholder(index int) string { return sqlPlaceholder }
+// sqlite 默认编译选项下 UPDATE DELETE 不支持 LIMIT, 设置了 QB.Limit 时 panic
+func (SQLiteDialect) LimitOffset(statement Statement, limit int, offset int) (raw Raw) {
+	if statement != statement.Enum().Select {
+		mustNoLimit("sqlite3", statement, limit, offset)
+		return
+	}
+	// sqlite 中 OFFSET 必须跟在 LIMIT 之后，LIMIT -1 表示不限制
+	if limit == 0 && offset != 0 {
+		return Raw{"LIMIT -1 OFFSET ?", []interface{}{offset}}
+	}
+	return limitOffset(limit, offset)
+}
+// sqlite 是库级锁，不支持 FOR UPDATE FOR SHARE, 设置了 QB.Lock 时 panic, 避免误以为已经锁定了数据
+func (SQLiteDialect) Lock(lock SelectLock) string {
+	panic(errors.New("goclub/sql: sqlite3 not support SELECT " + lock.String() + ", remove QB.Lock"))
+}
+// RIGHT JOIN FULL OUTER JOIN 需要 sqlite 3.39.0 及以上版本
+func (SQLiteDialect) Join(joinType JoinType) string { return joinType.String() }
+// SQLITE_MAX_VARIABLE_NUMBER 在 3.32.0 之前默认为 999
+func (SQLiteDialect) MaxPlaceholders() int { return 999 }
+func (SQLiteDialect) InsertInto(ignore bool, replace bool) string {
+	if replace {
+		return "REPLACE INTO"
+	}
+	if ignore {
+		return "INSERT OR IGNORE INTO"
+	}
+	return "INSERT INTO"
+}
+func (SQLiteDialect) OnConflict(ignore bool, conflictColumns []Column, sets string) string {
+	// INSERT OR IGNORE 已经处理了冲突
+	if sets == "" {
+		return ""
+	}
+	return onConflict(false, conflictColumns, sets)
+}
+func (SQLiteDialect) InsertValue(wrappedColumn string) string { return "excluded." + wrappedColumn }
+// RETURNING 需要 sqlite 3.35.0 及以上版本
+func (SQLiteDialect) SupportReturning() bool { return true }
+
+// ON CONFLICT (`id`) DO UPDATE SET ... 或 ON CONFLICT DO NOTHING
+func onConflict(ignore bool, conflictColumns []Column, sets string) string {
+	if sets != "" {
+		if len(conflictColumns) == 0 {
+			panic(errors.New("goclub/sql: ON CONFLICT DO UPDATE must set QB.ConflictColumn"))
+		}
+		return "ON CONFLICT (" + strings.Join(columnsToStrings(conflictColumns), ",") + ") DO UPDATE SET " + sets
+	}
+	if ignore {
+		return "ON CONFLICT DO NOTHING"
+	}
+	return ""
+}
+func mustNoLimit(dialectName string, statement Statement, limit int, offset int) {
+	if limit != 0 || offset != 0 {
+		panic(errors.New("goclub/sql: " + dialectName + " " + statement.String() + " not support LIMIT OFFSET, remove QB.Limit QB.Offset"))
+	}
+}
+// UpdateModel HardDeleteModel 等通过主键定位数据, mysql 中额外使用 LIMIT 1 防止误操作, 其他数据库不支持 UPDATE DELETE LIMIT
+func modelLimit(dialect Dialect) int {
+	if _, isMySQL := coalesceDialect(dialect).(MySQLDialect); isMySQL {
+		return 1
+	}
+	return 0
+}
+func limitOffset(limit int, offset int) (raw Raw) {
+	var sqlList stringQueue
+	if limit != 0 {
+		sqlList.Push("LIMIT ?")
+		raw.Values = append(raw.Values, limit)
+	}
+	if offset != 0 {
+		sqlList.Push("OFFSET ?")
+		raw.Values = append(raw.Values, offset)
+	}
+	raw.Query = sqlList.Join(" ")
+	return
+}
+
+// 根据 sql.Open(driverName) 的 driverName 选择方言，无法识别时使用 MySQL
+func DialectByDriverName(driverName string) Dialect {
+	switch driverName {
+	case "postgres", "pgx", "pq":
+		return PostgreSQLDialect{}
+	case "sqlite3", "sqlite":
+		return SQLiteDialect{}
+	default:
+		return MySQLDialect{}
+	}
+}
+func coalesceDialect(dialect Dialect) Dialect {
+	if dialect == nil {
+		return MySQLDialect{}
+	}
+	return dialect
+}
+
+// 将 MySQL 风格的 SQL 转换为 dialect 的 SQL
+// 反引号包裹的标识符使用 dialect.QuoteIdentifier 重新包裹，? 使用 dialect.Placeholder 替换
+// 单引号和双引号中的内容视为字符串原样保留
+func rebind(dialect Dialect, query string) string {
+	if _, isMySQL := dialect.(MySQLDialect); isMySQL {
+		return query
+	}
+	var builder strings.Builder
+	placeholderIndex := 0
+	for i := 0; i < len(query); i++ {
+		char := query[i]
+		switch char {
+		case '\'', '"':
+			end := strings.IndexByte(query[i+1:], char)
+			if end == -1 {
+				builder.WriteString(query[i:])
+				return builder.String()
+			}
+			builder.WriteString(query[i : i+end+2])
+			i += end + 1
+		case '`':
+			end := strings.IndexByte(query[i+1:], '`')
+			if end == -1 {
+				builder.WriteString(query[i:])
+				return builder.String()
+			}
+			builder.WriteString(dialect.QuoteIdentifier(query[i+1 : i+1+end]))
+			i += end + 1
+		case '?':
+			placeholderIndex++
+			builder.WriteString(dialect.Placeholder(placeholderIndex))
+		default:
+			builder.WriteByte(char)
+		}
+	}
+	return builder.String()
+}
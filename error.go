@@ -0,0 +1,213 @@
+package sq
+
+import (
+	"database/sql/driver"
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"strings"
+)
+
+// 驱动错误的分类, 用于代替直接比较 mysql 错误码 1062 或 postgres SQLSTATE 23505
+type ErrorKind uint8
+const (
+	ErrorKindUnknown ErrorKind = iota
+	// 唯一索引冲突
+	ErrorKindDuplicateKey
+	// 死锁, 可以重试整个事务
+	ErrorKindDeadlock
+	// 锁等待超时, 可以重试整个事务
+	ErrorKindLockWaitTimeout
+	// 外键约束失败
+	ErrorKindForeignKeyViolation
+	// 数据超过字段长度
+	ErrorKindDataTooLong
+	// 连接断开, SQL 可能已经执行也可能没有执行
+	ErrorKindConnectionLost
+)
+func (kind ErrorKind) String() string {
+	switch kind {
+	case ErrorKindDuplicateKey:
+		return "duplicate key"
+	case ErrorKindDeadlock:
+		return "deadlock"
+	case ErrorKindLockWaitTimeout:
+		return "lock wait timeout"
+	case ErrorKindForeignKeyViolation:
+		return "foreign key violation"
+	case ErrorKindDataTooLong:
+		return "data too long"
+	case ErrorKindConnectionLost:
+		return "connection lost"
+	}
+	return "unknown"
+}
+
+// 可选, Dialect 实现后用于识别驱动错误, 未实现时会依次尝试 mysql postgres sqlite 的规则
+type ErrorTranslator interface {
+	TranslateError(err error) ErrorKind
+}
+
+// 能识别分类的驱动错误会被包装为 *sq.Error, 通过 errors.As 可以获取执行的 SQL 和参数
+// 	var sqlErr *sq.Error
+// 	if errors.As(err, &sqlErr) {
+// 		log.Print(sqlErr.Kind, sqlErr.Query, sqlErr.Values)
+// 	}
+type Error struct {
+	Kind ErrorKind
+	Query string
+	Values []interface{}
+	// 驱动返回的原始错误
+	Err error
+}
+func (e *Error) Error() string {
+	return "goclub/sql: " + e.Kind.String() + ": " + e.Err.Error() + " SQL: " + e.Query
+}
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// 唯一索引冲突 mysql: 1062 postgres: 23505 sqlite: UNIQUE constraint failed
+func IsDuplicateKey(err error) bool {
+	return errorKindOf(err) == ErrorKindDuplicateKey
+}
+// 死锁 mysql: 1213 postgres: 40P01 40001
+func IsDeadlock(err error) bool {
+	return errorKindOf(err) == ErrorKindDeadlock
+}
+// 锁等待超时 mysql: 1205 postgres: 55P03 sqlite: database is locked
+func IsLockWaitTimeout(err error) bool {
+	return errorKindOf(err) == ErrorKindLockWaitTimeout
+}
+// 外键约束失败 mysql: 1451 1452 postgres: 23503 sqlite: FOREIGN KEY constraint failed
+func IsForeignKeyViolation(err error) bool {
+	return errorKindOf(err) == ErrorKindForeignKeyViolation
+}
+// 数据超过字段长度 mysql: 1406 postgres: 22001, sqlite 不限制字段长度
+func IsDataTooLong(err error) bool {
+	return errorKindOf(err) == ErrorKindDataTooLong
+}
+// 连接断开 driver.ErrBadConn mysql: 2006 2013 postgres: 08xxx 57P01
+func IsConnectionLost(err error) bool {
+	return errorKindOf(err) == ErrorKindConnectionLost
+}
+
+func errorKindOf(err error) ErrorKind {
+	if err == nil {
+		return ErrorKindUnknown
+	}
+	var sqlErr *Error
+	if errors.As(err, &sqlErr) {
+		return sqlErr.Kind
+	}
+	// 没有经过 goclub/sql 执行的错误, 例如 tx.Core.Commit() 返回的错误
+	return translateError(nil, err)
+}
+func translateError(dialect Dialect, err error) ErrorKind {
+	if translator, ok := dialect.(ErrorTranslator); ok {
+		return translator.TranslateError(err)
+	}
+	for _, translator := range []ErrorTranslator{MySQLDialect{}, PostgreSQLDialect{}, SQLiteDialect{}} {
+		kind := translator.TranslateError(err)
+		if kind != ErrorKindUnknown {
+			return kind
+		}
+	}
+	return ErrorKindUnknown
+}
+// 无法识别分类的错误原样返回, 保证 err == context.Canceled 等判断不受影响
+func wrapError(dialect Dialect, query string, values []interface{}, err error) error {
+	if err == nil {
+		return nil
+	}
+	var sqlErr *Error
+	if errors.As(err, &sqlErr) {
+		return err
+	}
+	kind := translateError(dialect, err)
+	if kind == ErrorKindUnknown {
+		return err
+	}
+	return &Error{Kind: kind, Query: query, Values: values, Err: err}
+}
+
+func (MySQLDialect) TranslateError(err error) ErrorKind {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return ErrorKindConnectionLost
+	}
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return ErrorKindUnknown
+	}
+	switch mysqlErr.Number {
+	case 1062, 1586:
+		return ErrorKindDuplicateKey
+	case 1213:
+		return ErrorKindDeadlock
+	case 1205:
+		return ErrorKindLockWaitTimeout
+	case 1216, 1217, 1451, 1452:
+		return ErrorKindForeignKeyViolation
+	case 1406:
+		return ErrorKindDataTooLong
+	// 2006 server has gone away, 2013 lost connection, 1053 server shutdown, 1927 connection killed
+	case 2006, 2013, 1053, 1927:
+		return ErrorKindConnectionLost
+	}
+	return ErrorKindUnknown
+}
+func (PostgreSQLDialect) TranslateError(err error) ErrorKind {
+	if errors.Is(err, driver.ErrBadConn) {
+		return ErrorKindConnectionLost
+	}
+	state, has := sqlState(err) ; if !has {
+		return ErrorKindUnknown
+	}
+	switch state {
+	case "23505":
+		return ErrorKindDuplicateKey
+	// deadlock_detected, serialization_failure
+	case "40P01", "40001":
+		return ErrorKindDeadlock
+	// lock_not_available
+	case "55P03":
+		return ErrorKindLockWaitTimeout
+	case "23503":
+		return ErrorKindForeignKeyViolation
+	// string_data_right_truncation
+	case "22001":
+		return ErrorKindDataTooLong
+	// admin_shutdown, crash_shutdown, cannot_connect_now
+	case "57P01", "57P02", "57P03":
+		return ErrorKindConnectionLost
+	}
+	// 08 开头的都是 connection_exception
+	if strings.HasPrefix(state, "08") {
+		return ErrorKindConnectionLost
+	}
+	return ErrorKindUnknown
+}
+// github.com/mattn/go-sqlite3 的错误码需要引入 cgo 依赖, 所以根据错误信息判断
+func (SQLiteDialect) TranslateError(err error) ErrorKind {
+	if errors.Is(err, driver.ErrBadConn) {
+		return ErrorKindConnectionLost
+	}
+	message := err.Error()
+	switch {
+	case strings.Contains(message, "UNIQUE constraint failed"), strings.Contains(message, "PRIMARY KEY constraint failed"):
+		return ErrorKindDuplicateKey
+	case strings.Contains(message, "FOREIGN KEY constraint failed"):
+		return ErrorKindForeignKeyViolation
+	// SQLITE_BUSY SQLITE_LOCKED
+	case strings.Contains(message, "database is locked"), strings.Contains(message, "database table is locked"):
+		return ErrorKindLockWaitTimeout
+	}
+	return ErrorKindUnknown
+}
+// github.com/lib/pq 和 github.com/jackc/pgx 的错误都实现了 SQLState() string
+func sqlState(err error) (state string, has bool) {
+	var stateErr interface{ SQLState() string }
+	if errors.As(err, &stateErr) {
+		return stateErr.SQLState(), true
+	}
+	return "", false
+}
@@ -0,0 +1,78 @@
+package sq
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Database 和 Transaction 都实现了 Executor, 数据访问层的函数接收 Executor 即可同时支持事务内外调用
+// 	func (dal UserDAL) Create(ctx context.Context, user *User) error {
+// 		return dal.db.From(ctx).InsertModel(ctx, user)
+// 	}
+type Executor interface {
+	Insert(ctx context.Context, qb QB) (result sql.Result, err error)
+	InsertModel(ctx context.Context, ptr Model, checkSQL ...string) (err error)
+	UpsertModel(ctx context.Context, ptr Model, updateColumns []Column, checkSQL ...string) (result sql.Result, err error)
+	InsertModels(ctx context.Context, slicePtr interface{}, checkSQL ...string) (err error)
+
+	QueryRowScan(ctx context.Context, qb QB, desc ...interface{}) (has bool, err error)
+	QueryStruct(ctx context.Context, ptr Tabler, qb QB)  (has bool, err error)
+	QuerySlice(ctx context.Context, slicePtr interface{}, qb QB) (err error)
+	QuerySliceScaner(ctx context.Context, qb QB, scaner Scaner) (err error)
+	QueryIterator(ctx context.Context, qb QB, elemPtr Tabler) (iter *Iterator, err error)
+	QueryKeyset(ctx context.Context, slicePtr interface{}, qb QB, cursor string, perPage int) (page KeysetPage, err error)
+	QueryRelation(ctx context.Context, ptr Relation, qb QB) (has bool, err error)
+	QueryRelationSlice(ctx context.Context, relationSlicePtr interface{}, qb QB) (err error)
+
+	Count(ctx context.Context, qb QB) (count uint64, err error)
+	QueryPage(ctx context.Context, slicePtr interface{}, qb QB, page int, perPage int) (result Page, err error)
+	Has(ctx context.Context, qb QB) (has bool, err error)
+	Sum(ctx context.Context, column Column ,qb QB) (value sql.NullInt64, err error)
+
+	Update(ctx context.Context, qb QB) (result sql.Result, err error)
+	UpdateModel(ctx context.Context, ptr Model, updateData []Update, where []Condition, checkSQL ...string) (result sql.Result, err error)
+	SaveModel(ctx context.Context, ptr Trackable, checkSQL ...string) (result sql.Result, err error)
+	IncrementIntModel(ctx context.Context, ptr Model, props IncrementInt, checkSQL ...string) (affected bool, err error)
+	IncrementFloatModel(ctx context.Context, ptr Model, props IncrementFloat, checkSQL ...string) (affected bool, err error)
+	DecrementIntModel(ctx context.Context, ptr Model, props DecrementInt, checkSQL ...string) (affected bool, err error)
+	DecrementFloatModel(ctx context.Context, ptr Model, props DecrementFloat, checkSQL ...string) (affected bool, err error)
+
+	HardDelete(ctx context.Context, qb QB) (result sql.Result, err error)
+	HardDeleteModel(ctx context.Context, ptr Model, checkSQL ...string) (result sql.Result, err error)
+	SoftDelete(ctx context.Context, qb QB) (result sql.Result, err error)
+	SoftDeleteModel(ctx context.Context, ptr Model, checkSQL ...string) (result sql.Result, err error)
+
+	Restore(ctx context.Context, qb QB) (result sql.Result, err error)
+	RestoreModel(ctx context.Context, ptr Model, checkSQL ...string) (result sql.Result, err error)
+
+	ExecQB(ctx context.Context, qb QB, statement Statement) (result sql.Result, err error)
+	Exec(ctx context.Context, query string, values []interface{}) (result sql.Result, err error)
+
+	// Database 开启事务, Transaction 开启嵌套事务(savepoint)
+	Transaction(ctx context.Context, handle func (tx *Transaction) TxResult) (isRollback bool, err error)
+}
+func verifyExecutor() {
+	func (Executor) {}(&Database{})
+	func (Executor) {}(&Transaction{})
+}
+
+type txKey struct{}
+// 将事务放入 context, 通过 db.From(ctx) 取出
+// 	db.Transaction(ctx, func(tx *sq.Transaction) sq.TxResult {
+// 		ctx := sq.WithTx(ctx, tx)
+// 		err := userDAL.Create(ctx, &user) ; if err != nil {
+// 			return tx.RollbackWithError(err)
+// 		}
+// 		return tx.Commit()
+// 	})
+func WithTx(ctx context.Context, tx *Transaction) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+// 返回 context 中的事务, context 中没有事务时返回 db
+// 调用方需保证 context 中的事务由 db 开启
+func (db *Database) From(ctx context.Context) Executor {
+	tx, ok := ctx.Value(txKey{}).(*Transaction) ; if ok && tx != nil {
+		return tx
+	}
+	return db
+}
@@ -1,146 +1,273 @@
 package sq
 
-// 递增存在并发问题，现在不满意目前设计的接口，等以后在想怎么设计 2021年02月02日10:32:15 @nimoc。
-// // 递减可能存在并发问题，所以只在事务中处理
-// func (tx *Transaction) DecrementIntModel(ctx context.Context, ptr Model, props IncrementInt, checkSQL ...string) (affected bool, err error) {
-// 	field := props.Column.wrapField()
-// 	result, err := coreUpdateModel(ctx, tx.Core, ptr, []Data{
-// 		{
-// 			// SET age = age - ?
-// 			Raw: Raw{
-// 				Query: field + " = " + field + " - ?",
-// 				Values: []interface{}{props.Value},
-// 			},
-// 			OnUpdated: func() error {
-// 				return props.OnUpdated(props.Value)
-// 			},
-// 		},
-// 	}, []Condition{
-// 		ConditionRaw(
-// 			// WHERE age  >= stock
-// 			field + " >= " + props.AfterIncrementLessThanOrEqual.wrapField(),
-// 			[]interface{}{props.Value},
-// 		),
-// 	})
-// 	if err != nil {
-// 		return
-// 	}
-// 	rowsAffected, err := result.RowsAffected() ; if err != nil {
-// 		return
-// 	}
-// 	affected = rowsAffected !=0
-// 	return
-// }
-// type DecrementFloat struct {
-// 	Column Column
-// 	Value float64
-// 	AfterDecrementGreaterThanOrEqual Column
-// 	OnUpdated func(value float64) error
-// }
-// // 递减可能存在并发问题，所以只在事务中处理
-// func (tx *Transaction) DecrementFloatModel(ctx context.Context, ptr Model, props IncrementFloat, checkSQL ...string) (affected bool, err error) {
-// 	field := props.Column.wrapField()
-// 	result, err := coreUpdateModel(ctx, tx.Core, ptr, []Data{
-// 		{
-// 			// SET age = age - ?
-// 			Raw: Raw{
-// 				Query: field + " = " + field + " - ?",
-// 				Values: []interface{}{props.Value},
-// 			},
-// 			OnUpdated: func() error {
-// 				return props.OnUpdated(props.Value)
-// 			},
-// 		},
-// 	}, []Condition{
-// 		ConditionRaw(
-// 			// WHERE age  >= stock
-// 			field + " >= " + props.AfterIncrementLessThanOrEqual.wrapField(),
-// 			[]interface{}{props.Value},
-// 		),
-// 	})
-// 	if err != nil {
-// 		return
-// 	}
-// 	rowsAffected, err := result.RowsAffected() ; if err != nil {
-// 		return
-// 	}
-// 	affected = rowsAffected !=0
-// 	return
-// }
-// type DecrementInt struct {
-// 	Column Column
-// 	Value uint
-// 	AfterDecrementGreaterThanOrEqual Column
-// 	OnUpdated func(value uint) error
-// }
-// type IncrementInt struct {
-// 	Column Column
-// 	Value uint
-// 	AfterIncrementLessThanOrEqual Column
-// 	OnUpdated func(value uint) error
-// }
-// // 递增可能存在并发问题，所以只在事务中处理
-// func (tx *Transaction) IncrementIntModel(ctx context.Context, ptr Model, props IncrementInt) (affected bool, err error) {
-// 	field := props.Column.wrapField()
-// 	result, err := db.UpdateModel(ctx, ptr, []Data{
-// 		{
-// 			// SET age = age + ?
-// 			Raw: Raw{
-// 				Query: field + " = " + field + " + ?",
-// 				Values: []interface{}{props.Value},
-// 			},
-// 			OnUpdated: func() error {
-// 				return props.OnUpdated(props.Value)
-// 			},
-// 		},
-// 	}, []Condition{
-// 		ConditionRaw(
-// 			// WHERE age + ? <= stock
-// 			field + " + ? <= " + props.AfterIncrementLessThanOrEqual.wrapField(),
-// 			[]interface{}{props.Value},
-// 		),
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+)
+
+// 递增递减后的值的边界, 通过 sq.BoundValue(0) 或 sq.BoundColumn("stock") 创建, 零值表示不限制
+type Bound struct {
+	column Column
+	value interface{}
+	valid bool
+}
+// 边界为固定值, 例如余额不能小于 0: sq.BoundValue(0)
+func BoundValue(value interface{}) Bound {
+	return Bound{value: value, valid: true}
+}
+// 边界为同一行的另一个字段, 例如已售数量不能超过库存: sq.BoundColumn("stock")
+func BoundColumn(column Column) Bound {
+	return Bound{column: column, valid: true}
+}
+func (b Bound) raw() Raw {
+	if len(b.column) != 0 {
+		return Raw{b.column.wrapField(), nil}
+	}
+	return Raw{"?", []interface{}{b.value}}
+}
+
+// SET `column` = `column` + ?
+type IncrementInt struct {
+	Column Column
+	Value uint64
+	// 可选, 递增后的值不能大于 Max
+	Max Bound
+}
+// SET `column` = `column` + ?
+type IncrementFloat struct {
+	Column Column
+	// 应为正数
+	Value float64
+	// 可选, 递增后的值不能大于 Max
+	Max Bound
+}
+// SET `column` = `column` - ?
+type DecrementInt struct {
+	Column Column
+	Value uint64
+	// 可选, 递减后的值不能小于 Min
+	Min Bound
+}
+// SET `column` = `column` - ?
+type DecrementFloat struct {
+	Column Column
+	// 应为正数
+	Value float64
+	// 可选, 递减后的值不能小于 Min
+	Min Bound
+}
+
+// 原子递增, 不满足边界条件时 affected 为 false, 只有 affected 为 true 时才会修改 ptr 中对应的字段
+// 	affected, err := db.IncrementIntModel(ctx, &goods, sq.IncrementInt{
+// 		Column: goodsCol.Sold,
+// 		Value: 1,
+// 		Max: sq.BoundColumn(goodsCol.Stock),
 // 	})
-// 	if err != nil {
-// 		return
-// 	}
-// 	rowsAffected, err := result.RowsAffected() ; if err != nil {
-// 		return
-// 	}
-// 	affected = rowsAffected !=0
-// 	return
-// }
-// type IncrementFloat struct {
-// 	Column Column
-// 	Value float64
-// 	AfterIncrementLessThanOrEqual Column
-// 	OnUpdated func(value float64) error
-// }
-// func (tx *Transaction) IncrementFloatModel(ctx context.Context, ptr Model, props IncrementFloat) (affected bool, err error) {
-// 	field := props.Column.wrapField()
-// 	result, err := tx.UpdateModel(ctx, ptr, []Data{
-// 		{
-// 			// SET age = age + ?
-// 			Raw: Raw{
-// 				Query: field + " = " + field + " + ?",
-// 				Values: []interface{}{props.Value},
-// 			},
-// 			OnUpdated: func() error {
-// 				return props.OnUpdated(props.Value)
-// 			},
-// 		},
-// 	}, []Condition{
-// 		ConditionRaw(
-// 			// WHERE age + ? <= stock
-// 			field + " + ? <= " + props.AfterIncrementLessThanOrEqual.wrapField(),
-// 			[]interface{}{props.Value},
-// 		),
+func (db *Database) IncrementIntModel(ctx context.Context, ptr Model, props IncrementInt, checkSQL ...string) (affected bool, err error) {
+	return coreIncrementModel(ctx, db, ptr, props.Column, props.Value, false, props.Max, checkSQL...)
+}
+func (tx *Transaction) IncrementIntModel(ctx context.Context, ptr Model, props IncrementInt, checkSQL ...string) (affected bool, err error) {
+	return coreIncrementModel(ctx, tx, ptr, props.Column, props.Value, false, props.Max, checkSQL...)
+}
+func (db *Database) IncrementFloatModel(ctx context.Context, ptr Model, props IncrementFloat, checkSQL ...string) (affected bool, err error) {
+	return coreIncrementModel(ctx, db, ptr, props.Column, props.Value, false, props.Max, checkSQL...)
+}
+func (tx *Transaction) IncrementFloatModel(ctx context.Context, ptr Model, props IncrementFloat, checkSQL ...string) (affected bool, err error) {
+	return coreIncrementModel(ctx, tx, ptr, props.Column, props.Value, false, props.Max, checkSQL...)
+}
+// 原子递减, 不满足边界条件时 affected 为 false, 只有 affected 为 true 时才会修改 ptr 中对应的字段
+// 	affected, err := db.DecrementIntModel(ctx, &account, sq.DecrementInt{
+// 		Column: accountCol.Balance,
+// 		Value: amount,
+// 		Min: sq.BoundValue(0),
 // 	})
-// 	if err != nil {
-// 		return
-// 	}
-// 	rowsAffected, err := result.RowsAffected() ; if err != nil {
-// 		return
-// 	}
-// 	affected = rowsAffected !=0
-// 	return
-// }
+func (db *Database) DecrementIntModel(ctx context.Context, ptr Model, props DecrementInt, checkSQL ...string) (affected bool, err error) {
+	return coreIncrementModel(ctx, db, ptr, props.Column, props.Value, true, props.Min, checkSQL...)
+}
+func (tx *Transaction) DecrementIntModel(ctx context.Context, ptr Model, props DecrementInt, checkSQL ...string) (affected bool, err error) {
+	return coreIncrementModel(ctx, tx, ptr, props.Column, props.Value, true, props.Min, checkSQL...)
+}
+func (db *Database) DecrementFloatModel(ctx context.Context, ptr Model, props DecrementFloat, checkSQL ...string) (affected bool, err error) {
+	return coreIncrementModel(ctx, db, ptr, props.Column, props.Value, true, props.Min, checkSQL...)
+}
+func (tx *Transaction) DecrementFloatModel(ctx context.Context, ptr Model, props DecrementFloat, checkSQL ...string) (affected bool, err error) {
+	return coreIncrementModel(ctx, tx, ptr, props.Column, props.Value, true, props.Min, checkSQL...)
+}
+// 直接执行 UPDATE, 不经过 UpdateModel: 不会触发 BeforeUpdate AfterUpdate, 不会修改更新时间字段
+// 存在 `sq:"version"` 字段时在同一条 UPDATE 中将版本号加一, 避免之前查询的数据通过 UpdateModel 覆盖递增递减的结果
+func coreIncrementModel(ctx context.Context, storager Storager, ptr Model, column Column, value interface{}, decrement bool, bound Bound, checkSQL ...string) (affected bool, err error) {
+	rValue := reflect.ValueOf(ptr)
+	rType := rValue.Type()
+	if rType.Kind() != reflect.Ptr {
+		panic(errors.New("goclub/sql: IncrementModel DecrementModel " + rType.String() + " must be ptr"))
+	}
+	fieldValue, has := fieldValueByColumn(rValue, column) ; if !has {
+		return false, errors.New("goclub/sql: " + rType.String() + " has no field with `db:\"" + column.String() + "\"`")
+	}
+	if !canIncreaseField(fieldValue, value) {
+		return false, errors.New("goclub/sql: can not increase " + fieldValue.Type().String() + " field `" + column.String() + "` by " + reflect.TypeOf(value).String())
+	}
+	// Value 为 0 时 MySQL 的影响行数为 0 无法判断数据是否存在, 浮点数为负数时边界条件会反转
+	if !increaseValuePositive(value) {
+		return false, errors.New("goclub/sql: IncrementModel DecrementModel Value must be positive")
+	}
+	if increaseFieldOverflow(fieldValue, value, decrement) {
+		return false, errors.New("goclub/sql: IncrementModel DecrementModel " + fieldValue.Type().String() + " field `" + column.String() + "` overflow")
+	}
+	elemValue := rValue.Elem()
+	elemType := rType.Elem()
+	primaryIDInfo := struct {
+		HasID bool
+		IDValue interface{}
+	}{}
+	for i:=0;i<elemType.NumField();i++ {
+		if column, hasDBTag := elemType.Field(i).Tag.Lookup("db"); hasDBTag && column == "id" {
+			primaryIDInfo.HasID = true
+			primaryIDInfo.IDValue = elemValue.Field(i).Interface()
+		}
+	}
+	where, err := primaryKeyWhere(ptr, primaryIDInfo, elemType.Name()) ; if err != nil {
+		return
+	}
+	field := column.wrapField()
+	operator := " + "
+	if decrement {
+		operator = " - "
+	}
+	if bound.valid {
+		boundRaw := bound.raw()
+		if decrement {
+			// WHERE `balance` >= ? + ? 而不是 `balance` - ? >= ?, 避免 UNSIGNED 字段相减溢出
+			where = append(where, ConditionRaw(field + " >= " + boundRaw.Query + " + ?", append(boundRaw.Values, value)))
+		} else {
+			// WHERE `sold` + ? <= `stock`
+			where = append(where, ConditionRaw(field + " + ? <= " + boundRaw.Query, append([]interface{}{value}, boundRaw.Values...)))
+		}
+	}
+	updateData := []Update{
+		{Raw: Raw{field + " = " + field + operator + "?", []interface{}{value}}},
+	}
+	versionColumn, versionValue, hasVersion := versionField(elemValue)
+	if hasVersion {
+		updateData = append(updateData, Update{
+			Raw: Raw{versionColumn.wrapField() + " = " + versionColumn.wrapField() + " + 1", nil},
+		})
+	}
+	qb := QB{
+		Table: ptr,
+		Update: updateData,
+		Where: where,
+		Limit: modelLimit(storager.getDialect()),
+		CheckSQL: checkSQL,
+	}
+	qb.SQLChecker = storager.getSQLChecker()
+	qb.Dialect = storager.getDialect()
+	qb.scopeCtx = ctx
+	raw, err := qb.build(Statement("").Enum().Update) ; if err != nil {
+		return
+	}
+	result, err := execContext(ctx, storager, raw.Query, raw.Values) ; if err != nil {
+		return
+	}
+	rowsAffected, err := result.RowsAffected() ; if err != nil {
+		return
+	}
+	affected = rowsAffected != 0
+	if affected {
+		increaseField(fieldValue, value, decrement)
+		if hasVersion {
+			increaseVersion(versionValue)
+		}
+		// 同步快照, 避免之后的 SaveModel 用内存中的值覆盖其他并发的递增递减
+		if trackable, ok := ptr.(Trackable); ok && trackable.tracker().snapshot != nil {
+			trackable.tracker().snapshot[column] = snapshotValue(fieldValue)
+			if hasVersion {
+				trackable.tracker().snapshot[versionColumn] = snapshotValue(versionValue)
+			}
+		}
+	}
+	return
+}
+// 整数字段只能使用 IncrementInt DecrementInt, 浮点数字段两者都可以使用
+func canIncreaseField(fieldValue reflect.Value, value interface{}) bool {
+	switch fieldValue.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		_, ok := value.(uint64)
+		return ok
+	case reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+func increaseValuePositive(value interface{}) bool {
+	switch v := value.(type) {
+	case uint64:
+		return v > 0
+	case float64:
+		return v > 0
+	}
+	return false
+}
+// 递增递减后的值无法写回内存中的字段, 例如 int8 字段超过 127 或 uint 字段小于 0
+func increaseFieldOverflow(fieldValue reflect.Value, value interface{}, decrement bool) bool {
+	switch fieldValue.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		delta := value.(uint64)
+		if delta > math.MaxInt64 {
+			return true
+		}
+		current := fieldValue.Int()
+		if decrement {
+			if current < math.MinInt64 + int64(delta) {
+				return true
+			}
+			return fieldValue.OverflowInt(current - int64(delta))
+		}
+		if current > math.MaxInt64 - int64(delta) {
+			return true
+		}
+		return fieldValue.OverflowInt(current + int64(delta))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		delta := value.(uint64)
+		current := fieldValue.Uint()
+		if decrement {
+			return current < delta
+		}
+		if current > math.MaxUint64 - delta {
+			return true
+		}
+		return fieldValue.OverflowUint(current + delta)
+	}
+	return false
+}
+func increaseField(fieldValue reflect.Value, value interface{}, decrement bool) {
+	switch fieldValue.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		delta := int64(value.(uint64))
+		if decrement {
+			delta = -delta
+		}
+		fieldValue.SetInt(fieldValue.Int() + delta)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if decrement {
+			fieldValue.SetUint(fieldValue.Uint() - value.(uint64))
+		} else {
+			fieldValue.SetUint(fieldValue.Uint() + value.(uint64))
+		}
+	case reflect.Float32, reflect.Float64:
+		var delta float64
+		switch v := value.(type) {
+		case uint64:
+			delta = float64(v)
+		case float64:
+			delta = v
+		}
+		if decrement {
+			delta = -delta
+		}
+		fieldValue.SetFloat(fieldValue.Float() + delta)
+	}
+}
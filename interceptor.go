@@ -0,0 +1,157 @@
+package sq
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"reflect"
+	"strings"
+)
+
+// 拦截器, 包裹每一条 SQL 的执行, 可用于日志 监控 链路追踪 改写 SQL 和故障注入
+// 拦截器可以修改 ctx query values 后传给 next, 也可以不调用 next 直接返回错误
+// 	db.Use(func(ctx context.Context, statement sq.Statement, query string, values []interface{}, next sq.InterceptorNext) (sql.Result, error) {
+// 		startTime := time.Now()
+// 		result, err := next(ctx, query, values)
+// 		log.Print(statement, query, time.Since(startTime), err)
+// 		return result, err
+// 	})
+type Interceptor func(ctx context.Context, statement Statement, query string, values []interface{}, next InterceptorNext) (result sql.Result, err error)
+// 执行下一个拦截器, 最后一个拦截器的 next 会执行 SQL
+// INSERT UPDATE DELETE 返回数据库的 sql.Result
+// QueryStruct QuerySlice 等查询返回的 result.RowsAffected() 为查询到的行数
+// QueryIterator QuerySliceScaner 和 INSERT ... RETURNING 需要逐行读取, 返回的 result 为 nil
+type InterceptorNext func(ctx context.Context, query string, values []interface{}) (result sql.Result, err error)
+
+// 注册拦截器, 先注册的拦截器在外层, 通过 db.Transaction 创建的事务会继承 Database 的拦截器
+// Use 不是并发安全的, 应当在 sq.Open 之后立即调用
+func (db *Database) Use(interceptors ...Interceptor) {
+	db.interceptors = append(db.interceptors, interceptors...)
+}
+
+// 根据 SQL 的第一个单词判断语句类型, REPLACE 视为 INSERT
+func statementOf(query string) Statement {
+	query = strings.TrimLeft(query, " \t\r\n(")
+	end := strings.IndexAny(query, " \t\r\n(")
+	if end != -1 {
+		query = query[:end]
+	}
+	statement := Statement(strings.ToUpper(query))
+	if statement == "REPLACE" {
+		return statement.Enum().Insert
+	}
+	return statement
+}
+// 能识别分类的驱动错误会被包装为 *sq.Error
+func intercept(ctx context.Context, storager Storager, query string, values []interface{}, handle InterceptorNext) (result sql.Result, err error) {
+	result, err = interceptChain(ctx, storager, query, values, handle)
+	return result, wrapError(storager.getDialect(), query, values, err)
+}
+func interceptChain(ctx context.Context, storager Storager, query string, values []interface{}, handle InterceptorNext) (result sql.Result, err error) {
+	// 日志和慢查询在最内层记录, 以便记录拦截器修改后实际执行的 SQL
+	if logger := storager.getLogger(); logger != nil {
+		handle = logHandle(storager, logger, handle)
+	}
+	if slow := storager.getSlowQuery(); slow != nil {
+		handle = slowQueryHandle(storager, slow, handle)
+	}
+	interceptors := storager.getInterceptors()
+	if len(interceptors) == 0 {
+		return handle(ctx, query, values)
+	}
+	statement := statementOf(query)
+	next := handle
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor := interceptors[i]
+		inner := next
+		next = func(ctx context.Context, query string, values []interface{}) (sql.Result, error) {
+			return interceptor(ctx, statement, query, values, inner)
+		}
+	}
+	return next(ctx, query, values)
+}
+var errInterceptorSkipNext = errors.New("goclub/sql: interceptor must call next or return error")
+
+func execContext(ctx context.Context, storager Storager, query string, values []interface{}) (result sql.Result, err error) {
+	result, err = intercept(ctx, storager, query, values, func(ctx context.Context, query string, values []interface{}) (sql.Result, error) {
+		return storager.getCore().ExecContext(ctx, query, values...)
+	})
+	if err == nil && result == nil {
+		return nil, errInterceptorSkipNext
+	}
+	return
+}
+func queryxContext(ctx context.Context, storager Storager, query string, values []interface{}) (rows *sqlx.Rows, err error) {
+	_, err = intercept(ctx, storager, query, values, func(ctx context.Context, query string, values []interface{}) (result sql.Result, err error) {
+		rows, err = storager.getReadCore(ctx).QueryxContext(ctx, query, values...)
+		return
+	})
+	if err != nil {
+		// 拦截器在 next 成功后返回错误时需要释放连接
+		if rows != nil {
+			_ = rows.Close()
+		}
+		return nil, err
+	}
+	if rows == nil {
+		return nil, errInterceptorSkipNext
+	}
+	return
+}
+func selectContext(ctx context.Context, storager Storager, slicePtr interface{}, query string, values []interface{}) (err error) {
+	called := false
+	_, err = intercept(ctx, storager, query, values, func(ctx context.Context, query string, values []interface{}) (result sql.Result, err error) {
+		called = true
+		err = storager.getReadCore(ctx).SelectContext(ctx, slicePtr, query, values...) ; if err != nil {
+			return
+		}
+		return queryResult(reflect.ValueOf(slicePtr).Elem().Len()), nil
+	})
+	if err != nil {
+		return
+	}
+	if !called {
+		return errInterceptorSkipNext
+	}
+	return
+}
+// 查询一行数据并通过 scan 扫描, 没有数据时 has 为 false
+func queryRowScan(ctx context.Context, storager Storager, query string, values []interface{}, scan func(rows *sqlx.Rows) error) (has bool, err error) {
+	called := false
+	_, err = intercept(ctx, storager, query, values, func(ctx context.Context, query string, values []interface{}) (result sql.Result, err error) {
+		called = true
+		rows, err := storager.getReadCore(ctx).QueryxContext(ctx, query, values...) ; if err != nil {
+			return
+		}
+		defer func() {
+			closeErr := rows.Close() ; if closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}()
+		has = rows.Next()
+		if !has {
+			return queryResult(0), rows.Err()
+		}
+		err = scan(rows) ; if err != nil {
+			return
+		}
+		return queryResult(1), nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if !called {
+		return false, errInterceptorSkipNext
+	}
+	return
+}
+
+// 查询语句传递给拦截器的 sql.Result, RowsAffected 返回查询到的行数
+type queryResult int64
+func (queryResult) LastInsertId() (int64, error) {
+	return 0, errors.New("goclub/sql: LastInsertId is not supported by query")
+}
+func (rows queryResult) RowsAffected() (int64, error) {
+	return int64(rows), nil
+}
@@ -21,6 +21,8 @@ type table struct {
 	tableName string
 	softDeleteWhere func() Raw
 	softDeleteSet func() Raw
+	// Relation 实现了 ScopeTabler 时不为 nil
+	scopeTabler ScopeTabler
 }
 func (t table) TableName() string {
 	return t.tableName
@@ -45,6 +47,31 @@ type Model interface {
 	BeforeUpdate() error
 	AfterUpdate() error
 }
+// 以下生命周期触发函数是可选的，通过类型断言判断 Model 是否实现，已有的 Model 无需修改
+// HardDeleteModel 执行前触发
+type BeforeDeleter interface {
+	BeforeDelete() error
+}
+// HardDeleteModel SoftDeleteModel 执行成功后触发
+type AfterDeleter interface {
+	AfterDelete(result sql.Result) error
+}
+// SoftDeleteModel 执行前触发
+type BeforeSoftDeleter interface {
+	BeforeSoftDelete() error
+}
+// QueryStruct QuerySlice QueryRelation QueryRelationSlice Iterator.StructScan 扫描到数据后触发
+type AfterQueryer interface {
+	AfterQuery() error
+}
+// 可选, 实现后支持 QB.SoftDeleteMode = sq.OnlyTrashed 和 Restore RestoreModel
+// SoftDeleteDeletedAt SoftDeleteDeleteTime SoftDeleteIsDeleted 都已实现
+type SoftDeleteRestorer interface {
+	// 只匹配已删除的数据, 与 SoftDeleteWhere 相反
+	SoftDeletedWhere() Raw
+	// 恢复已删除的数据, 与 SoftDeleteSet 相反
+	SoftDeleteRestoreSet() Raw
+}
 type Relation interface {
 	TableName() string
 	SoftDeleteWhere() Raw
@@ -58,14 +85,20 @@ func (WithoutSoftDelete) SoftDeleteWhere() Raw {return Raw{}}
 type SoftDeleteDeletedAt struct {}
 func (SoftDeleteDeletedAt) SoftDeleteWhere() Raw {return Raw{"`deleted_at` IS NULL", nil}}
 func (SoftDeleteDeletedAt) SoftDeleteSet() Raw   {return Raw{"`deleted_at` = ?" ,[]interface{}{time.Now()}}}
+func (SoftDeleteDeletedAt) SoftDeletedWhere() Raw {return Raw{"`deleted_at` IS NOT NULL", nil}}
+func (SoftDeleteDeletedAt) SoftDeleteRestoreSet() Raw {return Raw{"`deleted_at` = NULL", nil}}
 
 type SoftDeleteDeleteTime struct {}
 func (SoftDeleteDeleteTime) SoftDeleteWhere() Raw {return Raw{"`delete_time` IS NULL", nil}}
 func (SoftDeleteDeleteTime) SoftDeleteSet() Raw   {return Raw{"`delete_time` = ?" ,[]interface{}{time.Now()}}}
+func (SoftDeleteDeleteTime) SoftDeletedWhere() Raw {return Raw{"`delete_time` IS NOT NULL", nil}}
+func (SoftDeleteDeleteTime) SoftDeleteRestoreSet() Raw {return Raw{"`delete_time` = NULL", nil}}
 
 type SoftDeleteIsDeleted struct {}
 func (SoftDeleteIsDeleted) SoftDeleteWhere() Raw {return Raw{"`is_deleted` = 0", nil}}
 func (SoftDeleteIsDeleted) SoftDeleteSet() Raw   {return Raw{"`is_deleted` = 1" ,nil}}
+func (SoftDeleteIsDeleted) SoftDeletedWhere() Raw {return Raw{"`is_deleted` = 1", nil}}
+func (SoftDeleteIsDeleted) SoftDeleteRestoreSet() Raw {return Raw{"`is_deleted` = 0", nil}}
 
 type DefaultLifeCycle struct {
 
@@ -77,7 +110,15 @@ func (v *DefaultLifeCycle) AfterUpdate() error {return nil}
 
 type Storager interface {
 	getCore() StoragerCore
+	// 查询时使用的连接, 配置了从库时 Database 会根据 ctx 选择从库
+	getReadCore(ctx context.Context) StoragerCore
 	getSQLChecker () SQLChecker
+	getDialect() Dialect
+	getMaxAllowedPacket() int
+	getInterceptors() []Interceptor
+	getLogger() Logger
+	getSlowQuery() *slowQuery
+	getTxID() string
 }
 type StoragerCore interface {
 	sqlx.Queryer
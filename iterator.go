@@ -0,0 +1,95 @@
+package sq
+
+import (
+	"context"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"reflect"
+)
+
+// 逐行读取查询结果，用于导出等数据量很大的场景，避免 QuerySlice 将所有数据加载到内存
+// 	iter, err := db.QueryIterator(ctx, sq.QB{...}, &User{}) ; if err != nil {...}
+// 	defer iter.Close()
+// 	for iter.Next() {
+// 		user := User{}
+// 		err := iter.StructScan(&user) ; if err != nil {...}
+// 	}
+// 	err = iter.Err() ; if err != nil {...}
+type Iterator struct {
+	rows *sqlx.Rows
+	closed bool
+	err error
+}
+// 是否有下一行数据，没有数据或出现错误时返回 false 并自动关闭
+func (iter *Iterator) Next() bool {
+	if iter.closed || iter.err != nil {
+		return false
+	}
+	if iter.rows.Next() {
+		return true
+	}
+	iter.setErr(iter.rows.Err())
+	iter.setErr(iter.Close())
+	return false
+}
+// 扫描当前行到 desc, 类似 sql.Rows{}.Scan()
+func (iter *Iterator) Scan(desc ...interface{}) error {
+	err := iter.rows.Scan(desc...)
+	iter.setErr(err)
+	return err
+}
+// 根据 `db` 标签扫描当前行到结构体, ptr 实现了 AfterQueryer 时会触发 AfterQuery
+func (iter *Iterator) StructScan(ptr interface{}) error {
+	err := iter.rows.StructScan(ptr)
+	if err == nil {
+		err = afterQuery(ptr)
+	}
+	iter.setErr(err)
+	return err
+}
+// 返回遍历过程中出现的第一个错误，包括 Scan StructScan rows.Err() rows.Close() 的错误
+func (iter *Iterator) Err() error {
+	return iter.err
+}
+// 可以重复调用, 应当使用 defer iter.Close() 保证连接被释放
+func (iter *Iterator) Close() error {
+	if iter.closed {
+		return nil
+	}
+	iter.closed = true
+	err := iter.rows.Close()
+	iter.setErr(err)
+	return err
+}
+func (iter *Iterator) setErr(err error) {
+	if err != nil && iter.err == nil {
+		iter.err = err
+	}
+}
+
+func (db *Database) QueryIterator(ctx context.Context, qb QB, elemPtr Tabler) (iter *Iterator, err error) {
+	err = qb.mustInTransaction() ; if err != nil {return}
+	return coreQueryIterator(replicaRead(ctx, qb), db, qb, elemPtr)
+}
+func (tx *Transaction) QueryIterator(ctx context.Context, qb QB, elemPtr Tabler) (iter *Iterator, err error) {
+	return coreQueryIterator(ctx, tx, qb, elemPtr)
+}
+// elemPtr 用于确定表名和 SELECT 的字段
+func coreQueryIterator(ctx context.Context, storager Storager, qb QB, elemPtr Tabler) (iter *Iterator, err error) {
+	if reflect.TypeOf(elemPtr).Kind() != reflect.Ptr {
+		panic(errors.New("QueryIterator(ctx, qb, elemPtr) " + reflect.TypeOf(elemPtr).String() + " must be ptr"))
+	}
+	qb.SQLChecker = storager.getSQLChecker()
+	qb.Dialect = storager.getDialect()
+	qb.scopeCtx = ctx
+	if qb.Table == nil {
+		qb.Table = elemPtr
+	}
+	raw, err := qb.build(Statement("").Enum().Select) ; if err != nil {
+		return
+	}
+	rows, err := queryxContext(ctx, storager, raw.Query, raw.Values) ; if err != nil {
+		return
+	}
+	return &Iterator{rows: rows}, nil
+}
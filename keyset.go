@@ -0,0 +1,275 @@
+package sq
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// keyset(seek) 分页结果, 游标为空字符串表示不存在上一页或下一页
+type KeysetPage struct {
+	Next string
+	Prev string
+}
+type keysetDirection string
+const keysetNext keysetDirection = "next"
+const keysetPrev keysetDirection = "prev"
+type keysetCursor struct {
+	Direction keysetDirection `json:"d"`
+	Values []keysetValue `json:"v"`
+}
+// 游标中的值需要保留类型，否则 int64 会被 json 转换为 float64 丢失精度
+type keysetValue struct {
+	Type string `json:"t"`
+	Value string `json:"v"`
+}
+
+func (db *Database) QueryKeyset(ctx context.Context, slicePtr interface{}, qb QB, cursor string, perPage int) (page KeysetPage, err error) {
+	err = qb.mustInTransaction() ; if err != nil {return}
+	return coreQueryKeyset(replicaRead(ctx, qb), db, slicePtr, qb, cursor, perPage)
+}
+func (tx *Transaction) QueryKeyset(ctx context.Context, slicePtr interface{}, qb QB, cursor string, perPage int) (page KeysetPage, err error) {
+	return coreQueryKeyset(ctx, tx, slicePtr, qb, cursor, perPage)
+}
+// 基于 qb.OrderBy 的 keyset 分页, qb.OrderBy 的排序方向必须一致且最后一个字段必须唯一(例如 id)
+// 	WHERE (`created_at`, `id`) > (?, ?) AND `deleted_at` IS NULL ORDER BY `created_at` ASC, `id` ASC LIMIT ?
+// cursor 为空字符串时查询第一页, 之后传入返回的 page.Next 或 page.Prev
+func coreQueryKeyset(ctx context.Context, storager Storager, slicePtr interface{}, qb QB, cursor string, perPage int) (page KeysetPage, err error) {
+	if perPage <= 0 {
+		return page, errors.New("goclub/sql: QueryKeyset(ctx, slicePtr, qb, cursor, perPage) perPage must be greater than 0")
+	}
+	decoded, err := decodeKeysetCursor(cursor) ; if err != nil {
+		return
+	}
+	keysetQB, err := qb.keyset(decoded) ; if err != nil {
+		return
+	}
+	// 多查询一条数据用于判断是否存在下一页(上一页)
+	keysetQB.Limit = perPage + 1
+	ptrValue := reflect.ValueOf(slicePtr)
+	if ptrValue.Kind() != reflect.Ptr || ptrValue.Elem().Kind() != reflect.Slice {
+		panic(errors.New("goclub/sql: QueryKeyset(ctx, slicePtr, qb, cursor, perPage) " + ptrValue.Type().String() + " must be slice ptr"))
+	}
+	sliceValue := ptrValue.Elem()
+	// 复用同一个 slice 查询多页时, 需要清空上一页的数据, 否则会影响是否存在下一页的判断和游标
+	sliceValue.SetLen(0)
+	err = coreQuerySlice(ctx, storager, slicePtr, keysetQB) ; if err != nil {
+		return
+	}
+	hasMore := sliceValue.Len() > perPage
+	if hasMore {
+		sliceValue.Set(sliceValue.Slice(0, perPage))
+	}
+	if decoded.Direction == keysetPrev {
+		reverseSlice(sliceValue)
+	}
+	if sliceValue.Len() == 0 {
+		return
+	}
+	hasNext := (decoded.Direction == keysetNext && hasMore) || decoded.Direction == keysetPrev
+	hasPrev := (decoded.Direction == keysetPrev && hasMore) || (decoded.Direction == keysetNext && cursor != "")
+	if hasNext {
+		page.Next, err = encodeKeysetCursor(keysetNext, qb.OrderBy, sliceValue.Index(sliceValue.Len()-1)) ; if err != nil {
+			return
+		}
+	}
+	if hasPrev {
+		page.Prev, err = encodeKeysetCursor(keysetPrev, qb.OrderBy, sliceValue.Index(0)) ; if err != nil {
+			return
+		}
+	}
+	return
+}
+// 与 Paging(page, perPage) 对应的 keyset 分页, 根据游标生成 WHERE (a, b) > (?, ?) ORDER BY a, b LIMIT ?
+// 游标为上一页时排序会反转, 需要自行反转查询结果, 一般情况下应该使用 QueryKeyset
+func (qb QB) Keyset(cursor string, perPage int) (QB, error) {
+	decoded, err := decodeKeysetCursor(cursor) ; if err != nil {
+		return qb, err
+	}
+	qb, err = qb.keyset(decoded) ; if err != nil {
+		return qb, err
+	}
+	qb.Limit = perPage
+	return qb, nil
+}
+func (qb QB) keyset(cursor keysetCursor) (QB, error) {
+	if len(qb.OrderBy) == 0 {
+		return qb, errors.New("goclub/sql: keyset paging must set qb.OrderBy")
+	}
+	orderType := qb.OrderBy[0].Type
+	for _, order := range qb.OrderBy {
+		if order.Type != orderType {
+			return qb, errors.New("goclub/sql: keyset paging qb.OrderBy must have same ASC or DESC")
+		}
+	}
+	// 查询上一页时反向排序，查询后再将结果反转
+	if cursor.Direction == keysetPrev {
+		reverseType := ASC
+		if orderType == ASC {
+			reverseType = DESC
+		}
+		var orderBy []OrderBy
+		for _, order := range qb.OrderBy {
+			orderBy = append(orderBy, OrderBy{Column: order.Column, Type: reverseType})
+		}
+		qb.OrderBy = orderBy
+		orderType = reverseType
+	}
+	if len(cursor.Values) == 0 {
+		return qb, nil
+	}
+	if len(cursor.Values) != len(qb.OrderBy) {
+		return qb, errors.New("goclub/sql: keyset cursor does not match qb.OrderBy")
+	}
+	var columns []Column
+	var placeholders []string
+	var values []interface{}
+	for i, order := range qb.OrderBy {
+		columns = append(columns, order.Column)
+		placeholders = append(placeholders, sqlPlaceholder)
+		value, err := cursor.Values[i].decode() ; if err != nil {
+			return qb, err
+		}
+		values = append(values, value)
+	}
+	symbol := ">"
+	if orderType == DESC {
+		symbol = "<"
+	}
+	condition := ConditionRaw("(" + strings.Join(columnsToStrings(columns), ", ") + ") " + symbol + " (" + strings.Join(placeholders, ", ") + ")", values)
+	switch {
+	case qb.WhereRaw.Query != "":
+		qb.WhereRaw = Raw{
+			Query: "(" + qb.WhereRaw.Query + ") AND " + condition.OP.Query,
+			Values: append(append([]interface{}{}, qb.WhereRaw.Values...), values...),
+		}
+	case len(qb.WhereOR) != 0:
+		var whereOR [][]Condition
+		for _, where := range qb.WhereOR {
+			whereOR = append(whereOR, append(append([]Condition{}, where...), condition))
+		}
+		qb.WhereOR = whereOR
+	default:
+		qb.Where = append(append([]Condition{}, qb.Where...), condition)
+	}
+	return qb, nil
+}
+func decodeKeysetCursor(cursor string) (decoded keysetCursor, err error) {
+	if cursor == "" {
+		decoded.Direction = keysetNext
+		return
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cursor) ; if err != nil {
+		return decoded, errors.New("goclub/sql: keyset cursor is invalid")
+	}
+	err = json.Unmarshal(data, &decoded) ; if err != nil {
+		return decoded, errors.New("goclub/sql: keyset cursor is invalid")
+	}
+	if decoded.Direction != keysetNext && decoded.Direction != keysetPrev {
+		return decoded, errors.New("goclub/sql: keyset cursor is invalid")
+	}
+	return
+}
+func encodeKeysetCursor(direction keysetDirection, orderBy []OrderBy, item reflect.Value) (cursor string, err error) {
+	decoded := keysetCursor{Direction: direction}
+	for _, order := range orderBy {
+		fieldValue, has := fieldValueByColumn(item, order.Column)
+		if !has {
+			return "", errors.New("goclub/sql: keyset paging " + item.Type().String() + " must has field `db:\"" + order.Column.String() + "\"`")
+		}
+		var value keysetValue
+		value, err = newKeysetValue(fieldValue.Interface()) ; if err != nil {
+			return
+		}
+		decoded.Values = append(decoded.Values, value)
+	}
+	data, err := json.Marshal(decoded) ; if err != nil {
+		return
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+func newKeysetValue(v interface{}) (value keysetValue, err error) {
+	if valuer, ok := v.(driver.Valuer); ok {
+		v, err = valuer.Value() ; if err != nil {
+			return
+		}
+	}
+	if t, ok := v.(time.Time); ok {
+		return keysetValue{"time", t.Format(time.RFC3339Nano)}, nil
+	}
+	if v == nil {
+		return keysetValue{"nil", ""}, nil
+	}
+	rValue := reflect.ValueOf(v)
+	switch rValue.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return keysetValue{"int", strconv.FormatInt(rValue.Int(), 10)}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return keysetValue{"uint", strconv.FormatUint(rValue.Uint(), 10)}, nil
+	case reflect.Float32, reflect.Float64:
+		return keysetValue{"float", strconv.FormatFloat(rValue.Float(), 'g', -1, 64)}, nil
+	case reflect.Bool:
+		return keysetValue{"bool", strconv.FormatBool(rValue.Bool())}, nil
+	case reflect.String:
+		return keysetValue{"string", rValue.String()}, nil
+	case reflect.Slice:
+		if rValue.Type().Elem().Kind() == reflect.Uint8 {
+			return keysetValue{"bytes", base64.StdEncoding.EncodeToString(rValue.Bytes())}, nil
+		}
+	}
+	return value, errors.New("goclub/sql: keyset paging not support column type " + rValue.Type().String())
+}
+func (value keysetValue) decode() (v interface{}, err error) {
+	switch value.Type {
+	case "time":
+		return time.Parse(time.RFC3339Nano, value.Value)
+	case "nil":
+		return nil, nil
+	case "int":
+		return strconv.ParseInt(value.Value, 10, 64)
+	case "uint":
+		return strconv.ParseUint(value.Value, 10, 64)
+	case "float":
+		return strconv.ParseFloat(value.Value, 64)
+	case "bool":
+		return strconv.ParseBool(value.Value)
+	case "string":
+		return value.Value, nil
+	case "bytes":
+		return base64.StdEncoding.DecodeString(value.Value)
+	}
+	return nil, errors.New("goclub/sql: keyset cursor is invalid")
+}
+// 根据 `db` 标签查找字段, 支持嵌套的结构体
+func fieldValueByColumn(rValue reflect.Value, column Column) (fieldValue reflect.Value, has bool) {
+	if rValue.Kind() == reflect.Ptr {
+		rValue = rValue.Elem()
+	}
+	rType := rValue.Type()
+	for i:=0;i<rType.NumField();i++ {
+		structField := rType.Field(i)
+		tag, hasTag := structField.Tag.Lookup("db")
+		if hasTag && tag == column.String() {
+			return rValue.Field(i), true
+		}
+		if !hasTag && structField.Type.Kind() == reflect.Struct {
+			fieldValue, has = fieldValueByColumn(rValue.Field(i), column)
+			if has {
+				return
+			}
+		}
+	}
+	return
+}
+func reverseSlice(sliceValue reflect.Value) {
+	swap := reflect.Swapper(sliceValue.Interface())
+	for i, j := 0, sliceValue.Len()-1; i < j; i, j = i+1, j-1 {
+		swap(i, j)
+	}
+}
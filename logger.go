@@ -0,0 +1,132 @@
+package sq
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 通过 Database.SetLogger 配置, 每条 SQL 执行后会调用一次 Log
+type Logger interface {
+	Log(ctx context.Context, event LogEvent)
+}
+// 一次 SQL 执行的日志
+type LogEvent struct {
+	Statement Statement
+	Query string
+	// 已脱敏的参数, 通过 sq.Redact() 包裹的值显示为 [redacted]
+	Values []interface{}
+	Duration time.Duration
+	// INSERT UPDATE DELETE 为影响行数, 查询为返回的行数, 无法获取时为 -1
+	Rows int64
+	Err error
+	// 非事务中执行时为空字符串
+	TxID string
+	// 调用 goclub/sql 的代码位置 file:line
+	Caller string
+}
+// 配置日志, 传入 nil 关闭日志. 通过 db.Transaction 创建的事务会继承 Database 的日志配置
+func (db *Database) SetLogger(logger Logger) {
+	db.logger = logger
+}
+
+// 被 Redact 包裹的值会正常传递给数据库, 但在日志中显示为 [redacted]
+// 	sq.And(userCol.Password, sq.Equal(sq.Redact(password)))
+func Redact(value interface{}) Redacted {
+	return Redacted{value: value}
+}
+type Redacted struct {
+	value interface{}
+}
+func (v Redacted) Value() (driver.Value, error) {
+	return driver.DefaultParameterConverter.ConvertValue(v.value)
+}
+func (Redacted) String() string {
+	return "[redacted]"
+}
+// []byte 通常是二进制数据, 日志中只记录长度
+func redactValues(values []interface{}) (redacted []interface{}) {
+	for _, value := range values {
+		switch v := value.(type) {
+		case Redacted:
+			value = v.String()
+		case []byte:
+			value = "[]byte(len=" + strconv.Itoa(len(v)) + ")"
+		}
+		redacted = append(redacted, value)
+	}
+	return
+}
+// 包裹 handle 在执行后记录日志
+func logHandle(storager Storager, logger Logger, handle InterceptorNext) InterceptorNext {
+	return func(ctx context.Context, query string, values []interface{}) (result sql.Result, err error) {
+		startTime := time.Now()
+		result, err = handle(ctx, query, values)
+		event := LogEvent{
+			Statement: statementOf(query),
+			Query: query,
+			Values: redactValues(values),
+			Duration: time.Since(startTime),
+			Rows: -1,
+			Err: err,
+			TxID: storager.getTxID(),
+			Caller: caller(),
+		}
+		if err == nil && result != nil {
+			rows, rowsErr := result.RowsAffected() ; if rowsErr == nil {
+				event.Rows = rows
+			}
+		}
+		logger.Log(ctx, event)
+		return
+	}
+}
+// 调用栈中第一个不属于 goclub/sql 的位置
+func caller() string {
+	pc := make([]uintptr, 32)
+	n := runtime.Callers(3, pc)
+	frames := runtime.CallersFrames(pc[:n])
+	for {
+		frame, more := frames.Next()
+		if !isInternalFrame(frame.Function) {
+			return frame.File + ":" + strconv.Itoa(frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+func isInternalFrame(function string) bool {
+	for _, prefix := range []string{"github.com/goclub/sql.", "github.com/jmoiron/sqlx.", "database/sql.", "runtime."} {
+		if strings.HasPrefix(function, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// 使用标准库 log 输出日志, Logger 为 nil 时使用 log.Print
+// 	db.SetLogger(sq.StdLogger{})
+type StdLogger struct {
+	Logger *log.Logger
+}
+func (std StdLogger) Log(ctx context.Context, event LogEvent) {
+	message := fmt.Sprintf("goclub/sql: %s %v rows:%d duration:%s caller:%s", event.Query, event.Values, event.Rows, event.Duration, event.Caller)
+	if event.TxID != "" {
+		message += " tx:" + event.TxID
+	}
+	if event.Err != nil {
+		message += " error:" + event.Err.Error()
+	}
+	if std.Logger == nil {
+		log.Print(message)
+		return
+	}
+	std.Logger.Print(message)
+}
@@ -0,0 +1,40 @@
+//go:build go1.21
+// +build go1.21
+
+package sq
+
+import (
+	"context"
+	"log/slog"
+)
+
+// 使用 log/slog 输出日志, Logger 为 nil 时使用 slog.Default()
+// 执行成功使用 Level 级别(默认 Info), 执行失败使用 Error 级别
+// 	db.SetLogger(sq.SlogLogger{Logger: slog.Default(), Level: slog.LevelDebug})
+type SlogLogger struct {
+	Logger *slog.Logger
+	Level slog.Level
+}
+func (s SlogLogger) Log(ctx context.Context, event LogEvent) {
+	logger := s.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	attrs := []slog.Attr{
+		slog.String("statement", event.Statement.String()),
+		slog.String("query", event.Query),
+		slog.Any("values", event.Values),
+		slog.Duration("duration", event.Duration),
+		slog.Int64("rows", event.Rows),
+		slog.String("caller", event.Caller),
+	}
+	if event.TxID != "" {
+		attrs = append(attrs, slog.String("tx_id", event.TxID))
+	}
+	level := s.Level
+	if event.Err != nil {
+		level = slog.LevelError
+		attrs = append(attrs, slog.String("error", event.Err.Error()))
+	}
+	logger.LogAttrs(ctx, level, "goclub/sql", attrs...)
+}
@@ -37,4 +37,84 @@ func (Migrate) Migrate20201004160444CreateUserTable(mi sq.Migrate) {
 		Collate: mi.Utf8mb4_unicode_ci(),
 	})
 }
-
+func (Migrate) Migrate20210305102236CreateLogTable(mi sq.Migrate) {
+	mi.CreateTable(sq.CreateTableQB{
+		TableName: "log",
+		PrimaryKey: []string{"id"},
+		Fields: append([]sq.MigrateField{
+			mi.Field("id").Int(10).Unsigned().AutoIncrement(),
+			mi.Field("message").Varchar(255).DefaultString(""),
+		}, mi.CUDTimestamp()...),
+		Engine: mi.Engine().InnoDB,
+		Charset: mi.Charset().Utf8mb4,
+		Collate: mi.Utf8mb4_unicode_ci(),
+	})
+}
+// 分片表 order_00 ~ order_03, 测试时两个分片库使用同一个数据库
+func (Migrate) Migrate20261017100000CreateOrderTable(mi sq.Migrate) {
+	for _, suffix := range []string{"_00", "_01", "_02", "_03"} {
+		mi.CreateTable(sq.CreateTableQB{
+			TableName: "order" + suffix,
+			PrimaryKey: []string{"id"},
+			Fields: []sq.MigrateField{
+				mi.Field("id").Type("bigint", 20).Unsigned().AutoIncrement(),
+				mi.Field("user_id").Type("bigint", 20).Unsigned().DefaultInt(0),
+				mi.Field("amount").Int(11).DefaultInt(0),
+			},
+			Key: map[string][]string{
+				"user_id": {"user_id"},
+			},
+			Engine: mi.Engine().InnoDB,
+			Charset: mi.Charset().Utf8mb4,
+			Collate: mi.Utf8mb4_unicode_ci(),
+		})
+	}
+}
+func (Migrate) Migrate20261017100100CreateArticleTable(mi sq.Migrate) {
+	mi.CreateTable(sq.CreateTableQB{
+		TableName: "article",
+		PrimaryKey: []string{"id"},
+		Fields: []sq.MigrateField{
+			mi.Field("id").Type("bigint", 20).Unsigned().AutoIncrement(),
+			mi.Field("title").Varchar(255).DefaultString(""),
+			mi.Field("version").Type("bigint", 20).Unsigned().DefaultInt(0),
+		},
+		Engine: mi.Engine().InnoDB,
+		Charset: mi.Charset().Utf8mb4,
+		Collate: mi.Utf8mb4_unicode_ci(),
+	})
+}
+func (Migrate) Migrate20261017100200CreateGoodsTable(mi sq.Migrate) {
+	mi.CreateTable(sq.CreateTableQB{
+		TableName: "goods",
+		PrimaryKey: []string{"id"},
+		Fields: []sq.MigrateField{
+			mi.Field("id").Type("bigint", 20).Unsigned().AutoIncrement(),
+			mi.Field("stock").Type("bigint", 20).Unsigned().DefaultInt(0),
+			mi.Field("sold").Type("bigint", 20).Unsigned().DefaultInt(0),
+			mi.Field("price").Type("double", 0).DefaultInt(0),
+			mi.Field("version").Type("bigint", 20).Unsigned().DefaultInt(0),
+		},
+		Engine: mi.Engine().InnoDB,
+		Charset: mi.Charset().Utf8mb4,
+		Collate: mi.Utf8mb4_unicode_ci(),
+	})
+}
+func (Migrate) Migrate20261017100300CreateNoteTable(mi sq.Migrate) {
+	mi.CreateTable(sq.CreateTableQB{
+		TableName: "note",
+		PrimaryKey: []string{"id"},
+		Fields: []sq.MigrateField{
+			mi.Field("id").Type("bigint", 20).Unsigned().AutoIncrement(),
+			mi.Field("tenant_id").Type("bigint", 20).Unsigned().DefaultInt(0),
+			mi.Field("body").Varchar(255).DefaultString(""),
+			mi.DeletedAtTimestamp(),
+		},
+		Key: map[string][]string{
+			"tenant_id": {"tenant_id"},
+		},
+		Engine: mi.Engine().InnoDB,
+		Charset: mi.Charset().Utf8mb4,
+		Collate: mi.Utf8mb4_unicode_ci(),
+	})
+}
@@ -1,8 +1,11 @@
 package sq_test
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	sq "github.com/goclub/sql"
+	"strconv"
 )
 
 // 当有一张 user 表
@@ -104,3 +107,180 @@ func (UserWithAddress) Column () (col struct{
 	return
 }
 
+// 通过 QB.Preload 预加载 user_address
+type UserWithAddresses struct {
+	ID IDUser `db:"id"`
+	Name string `db:"name"`
+	TableUser
+	sq.DefaultLifeCycle
+	Addresses []UserAddressWithUser
+}
+func (UserWithAddresses) Associations() []sq.Association {
+	return []sq.Association{
+		{Field: "Addresses", Type: sq.HasMany, Table: UserAddress{}, LocalKey: "id", ForeignKey: "user_id"},
+	}
+}
+type UserAddressWithUser struct {
+	UserID IDUser `db:"user_id"`
+	Address string `db:"address"`
+	TableUserAddress
+	sq.DefaultLifeCycle
+	User *User
+}
+func (UserAddressWithUser) TableName() string {return "user_address"}
+func (UserAddressWithUser) Associations() []sq.Association {
+	return []sq.Association{
+		{Field: "User", Type: sq.BelongsTo, Table: User{}, LocalKey: "user_id", ForeignKey: "id"},
+	}
+}
+
+type TableLog struct {
+	sq.SoftDeleteDeletedAt
+}
+func (TableLog) TableName() string {return "log"}
+// 自增id通过 `sq:"pk|autoincr"` 标记，InsertModel 后会自动回填
+type Log struct {
+	ID uint64 `db:"id" sq:"pk|autoincr"`
+	Message string `db:"message"`
+	sq.CreatedAtUpdatedAt
+	TableLog
+	sq.DefaultLifeCycle
+}
+// 除主键外没有其他字段, UpsertModel 没有需要更新的字段
+type LogID struct {
+	ID uint64 `db:"id" sq:"pk|autoincr"`
+	TableLog
+	sq.DefaultLifeCycle
+}
+// 实现可选的生命周期触发函数 BeforeDeleter AfterDeleter BeforeSoftDeleter AfterQueryer
+type LogHook struct {
+	ID uint64 `db:"id" sq:"pk|autoincr"`
+	Message string `db:"message"`
+	sq.CreatedAtUpdatedAt
+	TableLog
+	sq.DefaultLifeCycle
+	// 记录触发过的生命周期函数
+	Hooks []string
+}
+func (v *LogHook) BeforeUpdate() error {v.Hooks = append(v.Hooks, "BeforeUpdate"); return nil}
+func (v *LogHook) AfterUpdate() error {v.Hooks = append(v.Hooks, "AfterUpdate"); return nil}
+func (v *LogHook) BeforeDelete() error {v.Hooks = append(v.Hooks, "BeforeDelete"); return nil}
+func (v *LogHook) AfterDelete(result sql.Result) error {v.Hooks = append(v.Hooks, "AfterDelete"); return nil}
+func (v *LogHook) BeforeSoftDelete() error {v.Hooks = append(v.Hooks, "BeforeSoftDelete"); return nil}
+func (v *LogHook) AfterQuery() error {v.Hooks = append(v.Hooks, "AfterQuery"); return nil}
+
+// 根据 user_id 分为 2 个库 4 张表 order_00 ~ order_03
+type TableOrder struct {
+	sq.WithoutSoftDelete
+}
+func (TableOrder) TableName() string {return "order"}
+func (TableOrder) SoftDeleteSet() sq.Raw {return sq.Raw{}}
+func (TableOrder) ShardRule() sq.ShardRule {
+	return sq.ShardRule{
+		Column: "user_id",
+		Route: func(value interface{}) (shard sq.Shard, err error) {
+			userID, ok := value.(uint64) ; if !ok {
+				return shard, errors.New("order user_id must be uint64")
+			}
+			return sq.Shard{Database: int(userID % 4 / 2), TableSuffix: "_0" + strconv.FormatUint(userID % 4, 10)}, nil
+		},
+		Shards: []sq.Shard{{0, "_00"}, {0, "_01"}, {1, "_02"}, {1, "_03"}},
+	}
+}
+type Order struct {
+	ID uint64 `db:"id" sq:"pk|autoincr"`
+	UserID uint64 `db:"user_id"`
+	Amount int `db:"amount"`
+	TableOrder
+	sq.DefaultLifeCycle
+}
+
+// article 表通过 version 字段实现乐观锁
+// id	title	version
+type TableArticle struct {
+	sq.WithoutSoftDelete
+}
+func (TableArticle) TableName() string {return "article"}
+func (TableArticle) SoftDeleteSet() sq.Raw {return sq.Raw{}}
+type Article struct {
+	ID uint64 `db:"id" sq:"pk|autoincr"`
+	Title string `db:"title"`
+	sq.Version
+	TableArticle
+	sq.DefaultLifeCycle
+}
+
+// goods 表的库存和销量通过 IncrementIntModel DecrementIntModel 原子更新
+// id	stock	sold	price	version
+type TableGoods struct {
+	sq.WithoutSoftDelete
+}
+func (TableGoods) TableName() string {return "goods"}
+func (TableGoods) SoftDeleteSet() sq.Raw {return sq.Raw{}}
+type Goods struct {
+	ID uint64 `db:"id" sq:"pk|autoincr"`
+	Stock uint64 `db:"stock"`
+	Sold uint64 `db:"sold"`
+	Price float64 `db:"price"`
+	TableGoods
+	sq.DefaultLifeCycle
+}
+// 与 Goods 相同的表, 使用 version 字段实现乐观锁
+type VersionedGoods struct {
+	ID uint64 `db:"id" sq:"pk|autoincr"`
+	Stock uint64 `db:"stock"`
+	Sold uint64 `db:"sold"`
+	sq.Version
+	TableGoods
+	sq.DefaultLifeCycle
+}
+func (Goods) Column() (col struct{
+	Stock sq.Column
+	Sold sq.Column
+	Price sq.Column
+}) {
+	col.Stock = "stock"
+	col.Sold = "sold"
+	col.Price = "price"
+	return
+}
+
+// 组合 sq.Tracker 后可以通过 SaveModel 只更新发生变化的字段
+type TrackedUser struct {
+	ID IDUser `db:"id"`
+	Name string `db:"name"`
+	Age int `db:"age"`
+	sq.CreatedAtUpdatedAt
+	TableUser
+	sq.DefaultLifeCycle
+	sq.Tracker
+}
+
+// note 表通过 tenant_id 隔离不同租户的数据
+// id	tenant_id	body	deleted_at
+type tenantKey struct{}
+type TableNote struct {
+	sq.SoftDeleteDeletedAt
+}
+func (TableNote) TableName() string {return "note"}
+func (TableNote) Scopes(ctx context.Context) ([]sq.Scope, error) {
+	tenantID, ok := ctx.Value(tenantKey{}).(uint64) ; if !ok {
+		return nil, errors.New("missing tenant id")
+	}
+	return []sq.Scope{{"tenant_id", tenantID}}, nil
+}
+type Note struct {
+	ID uint64 `db:"id" sq:"pk|autoincr"`
+	TenantID uint64 `db:"tenant_id"`
+	Body string `db:"body"`
+	TableNote
+	sq.DefaultLifeCycle
+}
+// 作用域的值无法转换为字段类型
+type NoteStringTenant struct {
+	ID uint64 `db:"id" sq:"pk|autoincr"`
+	TenantID string `db:"tenant_id"`
+	Body string `db:"body"`
+	TableNote
+	sq.DefaultLifeCycle
+}
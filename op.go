@@ -13,6 +13,10 @@ type OP struct {
 	Multiple []OP
 	OrGroup []Condition
 	Ignore bool
+		// 由 sq.In 创建, Values 为列表中的值
+		inList bool
+		// 由 sq.SubQuery 创建, 生成 SQL 时才转换, 以便继承外层 QB 的 scopeCtx
+		subQuery *QB
 }
 func (op OP) sql(column Column, values *[]interface{}) string {
 	var and stringQueue
@@ -35,6 +39,12 @@ func (op OP) sql(column Column, values *[]interface{}) string {
 		} else {
 			and.Push(column.wrapField())
 			and.Push(op.Symbol)
+			if op.subQuery != nil {
+				raw := op.subQuery.SQLSelect()
+				and.Push("(" + raw.Query + ")")
+				*values = append(*values, raw.Values...)
+				return and.Join(" ")
+			}
 			if len(op.Placeholder) != 0 {
 				and.Push(op.Placeholder)
 			} else {
@@ -58,12 +68,9 @@ func NotEqual(v interface{}) OP {
 	}
 }
 func SubQuery(symbol string, qb QB) OP {
-	raw := qb.SQLSelect()
-	query, values := raw.Query, raw.Values
 	return OP {
-		Placeholder: "(" + query + ")",
 		Symbol: symbol,
-		Values: values,
+		subQuery: &qb,
 	}
 }
 func Like(s string) OP {
@@ -93,6 +100,7 @@ func In(slice interface{}) OP {
 		Symbol:      "IN",
 		Values:      values,
 		Placeholder: placeholder,
+		inList: true,
 	}
 }
 func LikeLeft(s string) OP {
@@ -0,0 +1,91 @@
+package sq
+
+import (
+	"context"
+	"errors"
+	"reflect"
+)
+
+type Page struct {
+	// 总条数
+	Total uint64
+	// 当前页码，从 1 开始
+	Page int
+	PerPage int
+	TotalPages int
+	HasNext bool
+}
+func (db *Database) QueryPage(ctx context.Context, slicePtr interface{}, qb QB, page int, perPage int) (result Page, err error) {
+	err = qb.mustInTransaction() ; if err != nil {return}
+	return coreQueryPage(replicaRead(ctx, qb), db, slicePtr, qb, page, perPage)
+}
+func (tx *Transaction) QueryPage(ctx context.Context, slicePtr interface{}, qb QB, page int, perPage int) (result Page, err error) {
+	return coreQueryPage(ctx, tx, slicePtr, qb, page, perPage)
+}
+// 使用同一个 QB 查询总数和分页数据
+// 	SELECT COUNT(*) FROM `user` WHERE `deleted_at` IS NULL
+// 	SELECT `id`, `name` FROM `user` WHERE `deleted_at` IS NULL ORDER BY `id` DESC LIMIT ? OFFSET ?
+// 总数为 0 时不会查询分页数据
+func coreQueryPage(ctx context.Context, storager Storager, slicePtr interface{}, qb QB, page int, perPage int) (result Page, err error) {
+	if reflect.TypeOf(slicePtr).Kind() != reflect.Ptr {
+		panic(errors.New("goclub/sql: QueryPage(ctx, slicePtr, qb, page, perPage) " + reflect.TypeOf(slicePtr).String() + " not pointer"))
+	}
+	if qb.Table == nil {
+		qb.Table = reflect.MakeSlice(reflect.TypeOf(slicePtr).Elem(), 1,1).Index(0).Interface().(Tabler)
+	}
+	if page <= 0 || perPage <= 0 {
+		return result, errors.New("goclub/sql: QueryPage(ctx, slicePtr, qb, page, perPage) page and perPage must be greater than 0")
+	}
+	pagingQB := qb.Paging(page, perPage)
+	result.Page = pagingQB.Offset / pagingQB.Limit + 1
+	result.PerPage = pagingQB.Limit
+	countQB := qb
+	countQB.CheckSQL = nil
+	countQB.scopeCtx = ctx
+	// countQB 会提前生成子查询的 SQL, 需要先读取分片和作用域
+	countQB, err = countQB.resolve(Statement("").Enum().Select) ; if err != nil {
+		return
+	}
+	countQB = countQB.countQB(storager.getDialect())
+	result.Total, err = coreCount(ctx, storager, countQB) ; if err != nil {
+		return
+	}
+	result.TotalPages = int((result.Total + uint64(result.PerPage) - 1) / uint64(result.PerPage))
+	result.HasNext = result.Page < result.TotalPages
+	if result.Total == 0 {
+		return
+	}
+	err = coreQuerySlice(ctx, storager, slicePtr, pagingQB) ; if err != nil {
+		return
+	}
+	return
+}
+// 生成 COUNT 使用的 QB, 去掉 ORDER BY LIMIT OFFSET
+// 存在 GROUP BY 或 UNION 时使用子查询 SELECT COUNT(*) FROM (...) AS `goclub_sql_count`
+func (qb QB) countQB(dialect Dialect) QB {
+	qb.OrderBy = nil
+	qb.OrderByRaw = Raw{}
+	qb.Limit = 0
+	qb.Offset = 0
+	qb.limitRaw = limitRaw{}
+	qb.Lock = ""
+	needSubQuery := len(qb.GroupBy) != 0 || qb.GroupByRaw.Query != "" || qb.UnionTable.Tables != nil
+	if !needSubQuery {
+		return qb
+	}
+	subQB := qb
+	subQB.Dialect = dialect
+	subQB.disableRebind = true
+	subQB.Debug = false
+	subQB.CheckSQL = nil
+	subQB.SQLChecker = nil
+	raw := subQB.SQLSelect()
+	return QB{
+		TableRaw: TableRaw{
+			TableName: Raw{"(" + raw.Query + ") AS `goclub_sql_count`", raw.Values},
+		},
+		Debug: qb.Debug,
+		CheckSQL: qb.CheckSQL,
+		SQLChecker: qb.SQLChecker,
+	}
+}
@@ -0,0 +1,208 @@
+package sq
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+type AssociationType uint8
+const (
+	// 一对多, 字段类型为 []T 或 []*T
+	HasMany AssociationType = iota + 1
+	// 多对一, 字段类型为 *T, 没有关联数据时为 nil
+	BelongsTo
+)
+// 关联关系, 通过 QB.Preload 预加载
+// 	func (User) Associations() []sq.Association {
+// 		return []sq.Association{
+// 			// SELECT * FROM `user_address` WHERE `user_id` IN (user.id...)
+// 			{Field: "Addresses", Type: sq.HasMany, Table: TableUserAddress{}, LocalKey: "id", ForeignKey: "user_id"},
+// 		}
+// 	}
+// 	func (Order) Associations() []sq.Association {
+// 		return []sq.Association{
+// 			// SELECT * FROM `user` WHERE `id` IN (order.user_id...)
+// 			{Field: "User", Type: sq.BelongsTo, Table: TableUser{}, LocalKey: "user_id", ForeignKey: "id"},
+// 		}
+// 	}
+type Association struct {
+	// 结构体中存放关联数据的字段名, 字段不要设置 db tag
+	Field string
+	Type AssociationType
+	// 关联表
+	Table Tabler
+	// 当前 Model 的字段
+	LocalKey Column
+	// 关联表的字段
+	ForeignKey Column
+}
+// 可选, 实现后支持 QB.Preload
+type Associationer interface {
+	Associations() []Association
+}
+// QueryStruct QuerySlice 查询后, 每个 Preload 执行一条 SELECT ... WHERE `ForeignKey` IN (?) 并将结果填充到 Field
+// IN 的占位符超过 Dialect.MaxPlaceholders 时拆分为多条 SELECT
+type Preload struct {
+	// Association.Field
+	Field string
+	// 可选, 关联查询的额外条件, 排序和嵌套的 Preload, Table 和 IN 条件会自动设置
+	QB QB
+}
+
+// parents 为可寻址的结构体
+func preload(ctx context.Context, storager Storager, parents []reflect.Value, preloads []Preload, debug bool) (err error) {
+	if len(parents) == 0 || len(preloads) == 0 {
+		return
+	}
+	parentType := parents[0].Type()
+	associationer, ok := parents[0].Addr().Interface().(Associationer) ; if !ok {
+		return errors.New("goclub/sql: QB.Preload " + parentType.String() + " must implements sq.Associationer")
+	}
+	associations := associationer.Associations()
+	for _, item := range preloads {
+		var association Association
+		var has bool
+		for _, a := range associations {
+			if a.Field == item.Field {
+				association, has = a, true
+				break
+			}
+		}
+		if !has {
+			return errors.New("goclub/sql: QB.Preload " + parentType.String() + ".Associations() has no field " + item.Field)
+		}
+		err = preloadAssociation(ctx, storager, parents, association, item.QB, debug) ; if err != nil {
+			return
+		}
+	}
+	return
+}
+func preloadAssociation(ctx context.Context, storager Storager, parents []reflect.Value, association Association, qb QB, debug bool) (err error) {
+	parentType := parents[0].Type()
+	structField, has := parentType.FieldByName(association.Field) ; if !has {
+		return errors.New("goclub/sql: QB.Preload " + parentType.String() + " has no field " + association.Field)
+	}
+	fieldType := structField.Type
+	var elemType reflect.Type
+	switch {
+	case association.Type == HasMany && fieldType.Kind() == reflect.Slice:
+		elemType = fieldType.Elem()
+	case association.Type == BelongsTo && fieldType.Kind() == reflect.Ptr:
+		elemType = fieldType
+	default:
+		return errors.New("goclub/sql: QB.Preload " + parentType.String() + "." + association.Field + " type " + fieldType.String() + " does not match association type")
+	}
+	if elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	// 收集去重后的 LocalKey
+	var keys []interface{}
+	keySet := map[string]bool{}
+	for _, parent := range parents {
+		localValue, has := fieldValueByColumn(parent, association.LocalKey) ; if !has {
+			return errors.New("goclub/sql: QB.Preload " + parentType.String() + " has no field `db:\"" + association.LocalKey.String() + "\"`")
+		}
+		key := associationKey(localValue)
+		if keySet[key] {
+			continue
+		}
+		keySet[key] = true
+		keys = append(keys, localValue.Interface())
+	}
+	childrenPtr := reflect.New(reflect.SliceOf(elemType))
+	if len(keys) != 0 {
+		qb.Table = association.Table
+		if len(qb.Select) == 0 && len(qb.SelectRaw) == 0 {
+			qb.Select = TagToColumns(reflect.New(elemType).Interface())
+		}
+		qb.Debug = qb.Debug || debug
+		where := qb.Where
+		chunkQB := func(chunkKeys []interface{}) QB {
+			chunkQB := qb
+			chunkQB.Where = append(append([]Condition{}, where...), Condition{association.ForeignKey, In(chunkKeys)})
+			return chunkQB
+		}
+		// IN 的占位符数量不能超过 Dialect.MaxPlaceholders, 需要扣除 QB 中其他条件使用的占位符
+		sizeQB := chunkQB(keys[:1])
+		sizeQB.Dialect = storager.getDialect()
+		sizeQB.scopeCtx = ctx
+		var raw Raw
+		raw, err = sizeQB.build(Statement("").Enum().Select) ; if err != nil {
+			return
+		}
+		chunkSize := coalesceDialect(sizeQB.Dialect).MaxPlaceholders() - len(raw.Values) + 1
+		if chunkSize <= 0 {
+			return errors.New("goclub/sql: QB.Preload " + parentType.String() + "." + association.Field + " placeholders exceed Dialect.MaxPlaceholders")
+		}
+		for start := 0; start < len(keys); start += chunkSize {
+			end := start + chunkSize
+			if end > len(keys) {
+				end = len(keys)
+			}
+			chunkPtr := reflect.New(reflect.SliceOf(elemType))
+			err = coreQuerySlice(ctx, storager, chunkPtr.Interface(), chunkQB(keys[start:end])) ; if err != nil {
+				return
+			}
+			childrenPtr.Elem().Set(reflect.AppendSlice(childrenPtr.Elem(), chunkPtr.Elem()))
+		}
+	}
+	children := childrenPtr.Elem()
+	// 根据 ForeignKey 分组
+	childIndexes := map[string][]int{}
+	for i:=0;i<children.Len();i++ {
+		foreignValue, has := fieldValueByColumn(children.Index(i), association.ForeignKey) ; if !has {
+			return errors.New("goclub/sql: QB.Preload " + elemType.String() + " has no field `db:\"" + association.ForeignKey.String() + "\"`")
+		}
+		key := associationKey(foreignValue)
+		childIndexes[key] = append(childIndexes[key], i)
+	}
+	for _, parent := range parents {
+		localValue, _ := fieldValueByColumn(parent, association.LocalKey)
+		indexes := childIndexes[associationKey(localValue)]
+		field := parent.FieldByName(association.Field)
+		switch fieldType.Kind() {
+		case reflect.Slice:
+			items := reflect.MakeSlice(fieldType, 0, len(indexes))
+			for _, index := range indexes {
+				child := children.Index(index)
+				if fieldType.Elem().Kind() == reflect.Ptr {
+					child = child.Addr()
+				}
+				items = reflect.Append(items, child)
+			}
+			field.Set(items)
+		case reflect.Ptr:
+			if len(indexes) != 0 {
+				field.Set(children.Index(indexes[0]).Addr())
+			} else {
+				field.Set(reflect.Zero(fieldType))
+			}
+		}
+	}
+	return
+}
+// LocalKey 和 ForeignKey 的类型可能不同(例如 IDUser 和 string), 统一转换为字符串比较
+func associationKey(value reflect.Value) string {
+	v := value.Interface()
+	if valuer, ok := v.(driver.Valuer); ok {
+		driverValue, err := valuer.Value() ; if err == nil {
+			v = driverValue
+		}
+	}
+	return fmt.Sprint(v)
+}
+// 可寻址的 *[]T 或 *[]*T 中的每一项
+func sliceElems(slicePtr interface{}) (elems []reflect.Value) {
+	sliceValue := reflect.ValueOf(slicePtr).Elem()
+	for i:=0;i<sliceValue.Len();i++ {
+		item := sliceValue.Index(i)
+		if item.Kind() == reflect.Ptr {
+			item = item.Elem()
+		}
+		elems = append(elems, item)
+	}
+	return
+}
@@ -1,6 +1,7 @@
 package sq
 
 import (
+	"context"
 	"errors"
 	"log"
 	"strings"
@@ -12,10 +13,16 @@ type Update struct {
 	Value interface{}
 	Raw Raw
 	OnUpdated func() error
+		insertValue bool
 }
 func Set(column Column, value interface{}) Update {
 	return Update{Column: column, Value: value}
 }
+// 用于 QB.OnDuplicateKeyUpdate 将字段更新为插入时的值
+// mysql: `name` = VALUES(`name`) postgres sqlite: "name" = EXCLUDED."name"
+func SetInsertValue(column Column) Update {
+	return Update{Column: column, insertValue: true}
+}
 // sq.Value(column, value)
 type Insert struct {
 	Column Column
@@ -24,13 +31,24 @@ type Insert struct {
 func Value(column Column, value interface{}) Insert {
 	return Insert{Column: column, Value: value}
 }
+// 批量插入 INSERT INTO t (a,b) VALUES (?,?),(?,?)
+// 通过 Database.Insert 执行时会根据占位符数量和 max_allowed_packet 自动拆分为多条 SQL
+type InsertMultiple struct {
+	Column []Column
+	Values [][]interface{}
+}
 
 type QB struct {
 	Table Tabler
 		tableName string
+		// 由 Sharding 设置, 为 nil 时根据分片键计算
+		shard *Shard
 	TableRaw TableRaw
 
 	DisableSoftDelete bool
+	// 默认只匹配未删除的数据, sq.WithTrashed 等同于 DisableSoftDelete, sq.OnlyTrashed 只匹配已删除的数据
+	// sq.OnlyTrashed 优先于 DisableSoftDelete, 同时设置时依然只匹配已删除的数据
+	SoftDeleteMode SoftDeleteMode
 		softDelete Raw
 
 	UnionTable UnionTable
@@ -42,6 +60,17 @@ type QB struct {
 
 	Update []Update
 	Insert []Insert
+	InsertMultiple InsertMultiple
+	// INSERT IGNORE INTO
+	InsertIgnore bool
+	// REPLACE INTO
+	Replace bool
+	// INSERT INTO ... ON DUPLICATE KEY UPDATE
+	OnDuplicateKeyUpdate []Update
+	// postgres sqlite 的 ON CONFLICT (column) 需要指定冲突的字段
+	ConflictColumn []Column
+	// INSERT INTO ... RETURNING `id` (mysql 不支持)
+	Returning []Column
 
 	Where []Condition
 	WhereOR [][]Condition
@@ -62,12 +91,28 @@ type QB struct {
 
 	Lock SelectLock
 
+	// Table 实现了 ShardTabler 且 WHERE 中不包含分片键时, 通过 Sharding 在所有分片中执行并合并结果
+	ShardFanOut bool
+
+	// Join 的表通过 Join.Table 设置时才会在 ON 中增加 ScopeTabler 的作用域条件, 只设置 Join.TableName 时需要在 On 中手动添加
 	Join []Join
 	Raw Raw
 
+	// QueryStruct QuerySlice 查询后预加载关联数据, Table 需要实现 Associationer
+	Preload []Preload
+
 	Debug bool
 	CheckSQL []string
 	SQLChecker SQLChecker
+	// 为空时使用 MySQLDialect, 通过 Database 执行时会自动使用 Database 的 Dialect
+	Dialect Dialect
+		// 嵌套在其他 QB 中时由外层 QB 统一转换
+		disableRebind bool
+		// 用于读取 ScopeTabler 的作用域, 子查询和 UNION 中的 QB 为空时继承外层的 scopeCtx
+		scopeCtx context.Context
+		// 由 resolve 提前读取的作用域条件
+		scopes []Condition
+		scopeResolved bool
 }
 func (qb QB) mustInTransaction() error {
 	if len(qb.Lock) == 0 {
@@ -80,6 +125,13 @@ type TableRaw struct {
 	TableName Raw
 	SoftDeleteWhere Raw
 }
+type SoftDeleteMode uint8
+const (
+	SoftDeleteDefault SoftDeleteMode = iota
+	WithTrashed
+	// 需要 QB.Table 实现 SoftDeleteRestorer
+	OnlyTrashed
+)
 type OrderBy struct {
 	Column Column
 	Type orderByType
@@ -102,9 +154,17 @@ type UnionTable struct {
 	UnionAll bool
 }
 func (union UnionTable) SQLSelect() (raw Raw) {
+	return union.sqlSelect(nil, nil)
+}
+func (union UnionTable) sqlSelect(dialect Dialect, scopeCtx context.Context) (raw Raw) {
 	var sqlList stringQueue
 	var subQueryList []string
 	for _, table := range union.Tables {
+		table.Dialect = dialect
+		table.disableRebind = true
+		if table.scopeCtx == nil {
+			table.scopeCtx = scopeCtx
+		}
 		subQV := table.SQLSelect()
 		subQueryList = append(subQueryList, "(" + subQV.Query + ")")
 		raw.Values = append(raw.Values, subQV.Values...)
@@ -135,6 +195,11 @@ type Join struct {
 	Type JoinType
 	TableName string
 	On string
+	// 可选, 设置后使用 Table.TableName() 代替 TableName, Table 实现了 ScopeTabler 时会在 ON 中增加作用域条件
+	Table Tabler
+		// 由 resolve 提前读取的作用域条件
+		scopes []Condition
+		scopeResolved bool
 }
 type Column string
 func (c Column) String() string { return string(c)}
@@ -142,11 +207,11 @@ func (c Column) wrapField() string {
 	s := c.String()
 	return "`" + strings.ReplaceAll(s, ".", "`.`") + "`"
 }
-func (c Column) wrapFieldWithAS() string {
+func (c Column) wrapFieldWithAS(dialect Dialect) string {
 	s := c.String()
 	column := c.wrapField()
 	if strings.Contains(s, ".") {
-		column += ` AS '` + s + `'`
+		column += ` AS ` + coalesceDialect(dialect).QuoteAlias(s)
 	}
 	return column
 }
@@ -186,19 +251,31 @@ func (qb QB) SQL(statement Statement) Raw {
 	if len(qb.Raw.Query) != 0 {
 		return qb.Raw
 	}
+	dialect := coalesceDialect(qb.Dialect)
+	if qb.scopeCtx != nil {
+		qb, _ = qb.mapNestedQB(func(nested QB) (QB, error) {
+			return nested, nil
+		})
+	}
 	var values []interface{}
 	var sqlList stringQueue
 	if statement == statement.Enum().Select && qb.UnionTable.Tables != nil{
-		unionRaw := qb.UnionTable.SQLSelect()
+		unionRaw := qb.UnionTable.sqlSelect(dialect, qb.scopeCtx)
 		sqlList.Push(unionRaw.Query)
 		values = append(values, unionRaw.Values...)
 	}
 	if qb.Table != nil {
-		qb.tableName = "`" + qb.Table.TableName() + "`"
+		qb.tableName = "`" + qb.Table.TableName() + qb.shardTableSuffix(statement) + "`"
 		switch statement {
 		case statement.Enum().Select,
 			 statement.Enum().Update:
 			qb.softDelete = qb.Table.SoftDeleteWhere()
+			if qb.SoftDeleteMode == OnlyTrashed {
+				restorer, ok := qb.Table.(SoftDeleteRestorer) ; if !ok {
+					panic(errors.New("goclub/sql: QB{SoftDeleteMode: sq.OnlyTrashed} Table " + qb.Table.TableName() + " must implements sq.SoftDeleteRestorer"))
+				}
+				qb.softDelete = restorer.SoftDeletedWhere()
+			}
 		case statement.Enum().Insert:
 		case statement.Enum().Delete:
 		default:
@@ -209,6 +286,9 @@ func (qb QB) SQL(statement Statement) Raw {
 		qb.tableName = qb.TableRaw.TableName.Query
 		values = append(values, qb.TableRaw.TableName.Values...)
 		qb.softDelete = qb.TableRaw.SoftDeleteWhere
+		if qb.SoftDeleteMode == OnlyTrashed {
+			panic(errors.New("goclub/sql: QB{SoftDeleteMode: sq.OnlyTrashed} can not use TableRaw, use QB.Table or QB.Where"))
+		}
 	}
 	statement.Switch(func(_Select int) {
 	  if qb.UnionTable.Tables == nil {
@@ -220,7 +300,7 @@ func (qb QB) SQL(statement Statement) Raw {
 			  if len(qb.Select) == 0 {
 				  sqlList.Push("*")
 			  } else {
-				  sqlList.Push(strings.Join(columnsToStringsWithAS(qb.Select), ", "))
+				  sqlList.Push(strings.Join(columnsToStringsWithAS(qb.Select, dialect), ", "))
 			  }
 		  } else{
 			  var rawColumns []string
@@ -237,32 +317,71 @@ func (qb QB) SQL(statement Statement) Raw {
 	  	sqlList.Push(qb.Index)
 		}
 		for _, join := range qb.Join {
-			sqlList.Push(join.Type.String())
-			sqlList.Push("`" + join.TableName + "`")
+			joinTableName := join.TableName
+			if join.Table != nil {
+				joinTableName = join.Table.TableName()
+			}
+			sqlList.Push(dialect.Join(join.Type))
+			sqlList.Push(Column(joinTableName).wrapField())
 			sqlList.Push("ON")
-			sqlList.Push(join.On)
+			scopeRaw := ToConditions(join.scopeConditions(qb.scopeCtx)).coreSQL("AND")
+			if len(scopeRaw.Query) != 0 {
+				sqlList.Push("(" + join.On + ") AND " + scopeRaw.Query)
+				values = append(values, scopeRaw.Values...)
+			} else {
+				sqlList.Push(join.On)
+			}
 		}
 	}, func(_Update bool) {
 		sqlList.Push("UPDATE")
 		sqlList.Push(qb.tableName)
 		sqlList.Push("SET")
-		var sets  []string
-		for _, data := range qb.Update {
-			if len(data.Raw.Query) !=0  {
-				sets = append(sets, data.Raw.Query)
-				values = append(values, data.Raw.Values...)
-			} else {
-				sets = append(sets, data.Column.wrapField()+"=?")
-				values = append(values, data.Value)
-			}
-		}
-		sqlList.Push(strings.Join(sets, ","))
+		sets := updateSetsSQL(qb.Update, dialect)
+		sqlList.Push(sets.Query)
+		values = append(values, sets.Values...)
 	}, func(_Delete string) {
 		sqlList.Push("DELETE FROM")
 		sqlList.Push(qb.tableName)
 	}, func(_Insert []int) {
-			sqlList.Push("INSERT INTO")
+			if err := qb.checkReplaceScopes(); err != nil {
+				panic(err)
+			}
+			sqlList.Push(dialect.InsertInto(qb.InsertIgnore, qb.Replace))
 			sqlList.Push(qb.tableName)
+			defer func() {
+				var sets Raw
+				if len(qb.OnDuplicateKeyUpdate) != 0 {
+					updates, err := qb.scopeConflictUpdates(dialect) ; if err != nil {
+						panic(err)
+					}
+					sets = updateSetsSQL(updates, dialect)
+					values = append(values, sets.Values...)
+				}
+				onConflict := dialect.OnConflict(qb.InsertIgnore, qb.ConflictColumn, sets.Query)
+				if onConflict != "" {
+					sqlList.Push(onConflict)
+				}
+				if len(qb.Returning) != 0 {
+					sqlList.Push("RETURNING")
+					sqlList.Push(strings.Join(columnsToStrings(qb.Returning), ", "))
+				}
+			}()
+			if len(qb.InsertMultiple.Column) != 0 {
+				multiple := qb.InsertMultiple
+				sqlList.Push("(" + strings.Join(columnsToStrings(multiple.Column), ",") + ")")
+				sqlList.Push("VALUES")
+				rowPlaceholder := "(" + strings.TrimSuffix(strings.Repeat("?,", len(multiple.Column)), ",") + ")"
+				var rows []string
+				for _, row := range multiple.Values {
+					if len(row) != len(multiple.Column) {
+						panic(errors.New("goclub/sql: InsertMultiple each Values length must equal Column length"))
+					}
+					rows = append(rows, rowPlaceholder)
+					values = append(values, row...)
+				}
+				sqlList.Push(strings.Join(rows, ","))
+				return
+			}
 			var columns []string
 			for _, item := range qb.Insert {
 				columns = append(columns, item.Column.wrapField())
@@ -300,7 +419,7 @@ func (qb QB) SQL(statement Statement) Raw {
 		if disableWhereIsEmpty && len(strings.TrimSpace(whereString)) == 0 {
 			return Raw{"goclub/sql:(MAYBE_FORGET_WHERE)", nil}
 		}
-		if !qb.DisableSoftDelete {
+		if qb.SoftDeleteMode == OnlyTrashed || (!qb.DisableSoftDelete && qb.SoftDeleteMode != WithTrashed) {
 			needSoftDelete := qb.softDelete.Query != ""
 			if needSoftDelete  {
 				whereSoftDelete := qb.softDelete
@@ -312,6 +431,24 @@ func (qb QB) SQL(statement Statement) Raw {
 				}
 			}
 		}
+		switch statement {
+		case statement.Enum().Select, statement.Enum().Update, statement.Enum().Delete:
+			scopes := qb.scopeConditions()
+			// 存在 JOIN 时使用表名限定作用域字段, 避免与 JOIN 的表中的同名字段冲突
+			if len(qb.Join) != 0 && len(scopes) != 0 {
+				scopes = qualifyScopes(qb.Table.TableName() + qb.shardTableSuffix(statement), scopes)
+			}
+			scopeRaw := ToConditions(scopes).coreSQL("AND")
+			if len(scopeRaw.Query) != 0 {
+				values = append(values, scopeRaw.Values...)
+				// 括号避免 WhereOR 中的 OR 绕过作用域
+				if len(whereString) != 0 {
+					whereString = "(" + whereString + ") AND " + scopeRaw.Query
+				} else {
+					whereString = scopeRaw.Query
+				}
+			}
+		}
 		if len(whereString) != 0 {
 			sqlList.Push("WHERE")
 			sqlList.Push(whereString)
@@ -344,9 +481,6 @@ func (qb QB) SQL(statement Statement) Raw {
 		sqlList.Push("GROUP BY")
 		sqlList.Push(strings.Join(columnsToStrings(qb.GroupBy), ", "))
 	}
-	if len(qb.Lock) != 0 {
-		sqlList.Push(qb.Lock.String())
-	}
 	// havaing
 	if qb.HavingRaw.Query != ""{
 		sqlList.Push("HAVING")
@@ -364,16 +498,20 @@ func (qb QB) SQL(statement Statement) Raw {
 	if qb.limitRaw.Valid {
 		limit = qb.limitRaw.Limit
 	}
-	if limit != 0 {
-		sqlList.Push("LIMIT ?")
-		values = append(values, qb.Limit)
-	}
 	// offset
-	if qb.Offset != 0 {
-		sqlList.Push("OFFSET ?")
-		values = append(values, qb.Offset)
+	limitOffsetRaw := dialect.LimitOffset(statement, limit, qb.Offset)
+	if limitOffsetRaw.Query != "" {
+		sqlList.Push(limitOffsetRaw.Query)
+		values = append(values, limitOffsetRaw.Values...)
+	}
+	// lock
+	if len(qb.Lock) != 0 {
+		sqlList.Push(dialect.Lock(qb.Lock))
 	}
 	query := sqlList.Join(" ")
+	if !qb.disableRebind {
+		query = rebind(dialect, query)
+	}
 	defer func() {
 		if qb.Debug {
 			log.Print("goclub/sql debug:\r\n" + query, "\r\n", values)
@@ -387,6 +525,112 @@ func (qb QB) SQL(statement Statement) Raw {
 	}()
 	return Raw{query, values}
 }
+// 通过 Database Transaction 执行时使用, 分片等无法在生成 SQL 时确定的错误通过 err 返回而不是 panic
+func (qb QB) build(statement Statement) (raw Raw, err error) {
+	qb, err = qb.resolve(statement) ; if err != nil {
+		return
+	}
+	return qb.SQL(statement), nil
+}
+func (qb QB) resolve(statement Statement) (QB, error) {
+	qb, err := qb.resolveShard(statement) ; if err != nil {
+		return qb, err
+	}
+	qb, err = qb.resolveScopes() ; if err != nil {
+		return qb, err
+	}
+	err = qb.checkScopes(statement) ; if err != nil {
+		return qb, err
+	}
+	return qb.mapNestedQB(func(nested QB) (QB, error) {
+		return nested.resolve(Statement("").Enum().Select)
+	})
+}
+// 依次处理 UNION 和 WHERE HAVING 子查询中的 QB, 处理前 scopeCtx 为空时继承外层的 scopeCtx
+func (qb QB) mapNestedQB(handle func(nested QB) (QB, error)) (_ QB, err error) {
+	mapQB := func(nested QB) (QB, error) {
+		if nested.scopeCtx == nil {
+			nested.scopeCtx = qb.scopeCtx
+		}
+		return handle(nested)
+	}
+	if len(qb.UnionTable.Tables) != 0 {
+		tables := make([]QB, len(qb.UnionTable.Tables))
+		for i, table := range qb.UnionTable.Tables {
+			tables[i], err = mapQB(table) ; if err != nil {
+				return qb, err
+			}
+		}
+		qb.UnionTable.Tables = tables
+	}
+	qb.Where, err = mapConditionsQB(qb.Where, mapQB) ; if err != nil {
+		return qb, err
+	}
+	if len(qb.WhereOR) != 0 {
+		whereOR := make([][]Condition, len(qb.WhereOR))
+		for i, where := range qb.WhereOR {
+			whereOR[i], err = mapConditionsQB(where, mapQB) ; if err != nil {
+				return qb, err
+			}
+		}
+		qb.WhereOR = whereOR
+	}
+	qb.Having, err = mapConditionsQB(qb.Having, mapQB) ; if err != nil {
+		return qb, err
+	}
+	return qb, nil
+}
+func mapConditionsQB(conditions []Condition, handle func(nested QB) (QB, error)) (_ []Condition, err error) {
+	if len(conditions) == 0 {
+		return conditions, nil
+	}
+	mapped := make([]Condition, len(conditions))
+	for i, condition := range conditions {
+		condition.OP, err = mapOPQB(condition.OP, handle) ; if err != nil {
+			return
+		}
+		mapped[i] = condition
+	}
+	return mapped, nil
+}
+func mapOPQB(op OP, handle func(nested QB) (QB, error)) (_ OP, err error) {
+	if op.subQuery != nil {
+		var subQuery QB
+		subQuery, err = handle(*op.subQuery) ; if err != nil {
+			return
+		}
+		op.subQuery = &subQuery
+	}
+	if len(op.Multiple) != 0 {
+		multiple := make([]OP, len(op.Multiple))
+		for i, subOP := range op.Multiple {
+			multiple[i], err = mapOPQB(subOP, handle) ; if err != nil {
+				return
+			}
+		}
+		op.Multiple = multiple
+	}
+	op.OrGroup, err = mapConditionsQB(op.OrGroup, handle) ; if err != nil {
+		return
+	}
+	return op, nil
+}
+func updateSetsSQL(updates []Update, dialect Dialect) (raw Raw) {
+	var sets []string
+	for _, data := range updates {
+		if len(data.Raw.Query) !=0  {
+			sets = append(sets, data.Raw.Query)
+			raw.Values = append(raw.Values, data.Raw.Values...)
+		} else if data.insertValue {
+			sets = append(sets, data.Column.wrapField() + " = " + dialect.InsertValue(data.Column.wrapField()))
+		} else {
+			sets = append(sets, data.Column.wrapField()+"=?")
+			raw.Values = append(raw.Values, data.Value)
+		}
+	}
+	raw.Query = strings.Join(sets, ",")
+	return
+}
 func (qb QB) SQLSelect() Raw {
 	return qb.SQL(Statement("").Enum().Select)
 }
@@ -1,6 +1,7 @@
 package sq_test
 
 import (
+	"context"
 	"errors"
 	sq "github.com/goclub/sql"
 	"github.com/stretchr/testify/assert"
@@ -55,6 +56,87 @@ func (suite TestQBSuite) TestDisableSoftDelete() {
 		assert.Equal(t, []interface{}(nil), values)
 	}
 }
+func (suite TestQBSuite) TestSoftDeleteMode() {
+	t := suite.T()
+	{
+		qb := sq.QB{
+			Table: User{},
+			SoftDeleteMode: sq.WithTrashed,
+		}
+		raw := qb.SQLSelect(); query, values :=  raw.Query, raw.Values
+		assert.Equal(t, "SELECT `id`, `name`, `age`, `created_at`, `updated_at` FROM `user`", query)
+		assert.Equal(t, []interface{}(nil), values)
+	}
+	{
+		qb := sq.QB{
+			Table: User{},
+			Where: sq.And("name", sq.Equal("nimo")),
+			SoftDeleteMode: sq.OnlyTrashed,
+		}
+		raw := qb.SQLSelect(); query, values :=  raw.Query, raw.Values
+		assert.Equal(t, "SELECT `id`, `name`, `age`, `created_at`, `updated_at` FROM `user` WHERE `name` = ? AND `deleted_at` IS NOT NULL", query)
+		assert.Equal(t, []interface{}{"nimo"}, values)
+	}
+	{
+		// OnlyTrashed 优先于 DisableSoftDelete
+		qb := sq.QB{
+			Table: User{},
+			Where: sq.And("name", sq.Equal("nimo")),
+			DisableSoftDelete: true,
+			SoftDeleteMode: sq.OnlyTrashed,
+		}
+		raw := qb.SQLSelect(); query, values :=  raw.Query, raw.Values
+		assert.Equal(t, "SELECT `id`, `name`, `age`, `created_at`, `updated_at` FROM `user` WHERE `name` = ? AND `deleted_at` IS NOT NULL", query)
+		assert.Equal(t, []interface{}{"nimo"}, values)
+	}
+	{
+		qb := sq.QB{
+			Table: User{},
+			Where: sq.And("id", sq.Equal(1)),
+			Update: []sq.Update{{Raw: User{}.SoftDeleteRestoreSet()}},
+			SoftDeleteMode: sq.OnlyTrashed,
+		}
+		raw := qb.SQLUpdate(); query, values :=  raw.Query, raw.Values
+		assert.Equal(t, "UPDATE `user` SET `deleted_at` = NULL WHERE `id` = ? AND `deleted_at` IS NOT NULL", query)
+		assert.Equal(t, []interface{}{1}, values)
+	}
+	assert.PanicsWithError(t, "goclub/sql: QB{SoftDeleteMode: sq.OnlyTrashed} Table order must implements sq.SoftDeleteRestorer", func() {
+		sq.QB{
+			Table: TableOrder{},
+			Where: sq.And("user_id", sq.Equal(uint64(1))),
+			SoftDeleteMode: sq.OnlyTrashed,
+		}.SQLSelect()
+	})
+}
+func (suite TestQBSuite) TestScope() {
+	t := suite.T()
+	ctx := context.WithValue(context.TODO(), tenantKey{}, uint64(1))
+	{
+		qb := sq.QB{
+			Table: User{},
+			Where: sq.And("id", sq.SubQueryContext(ctx, "IN", sq.QB{
+				Table: TableNote{},
+				Select: []sq.Column{"user_id"},
+			})),
+		}
+		raw := qb.SQLSelect(); query, values :=  raw.Query, raw.Values
+		assert.Equal(t, "SELECT `id`, `name`, `age`, `created_at`, `updated_at` FROM `user` WHERE `id` IN (SELECT `user_id` FROM `note` WHERE (`deleted_at` IS NULL) AND `tenant_id` = ?) AND `deleted_at` IS NULL", query)
+		assert.Equal(t, []interface{}{uint64(1)}, values)
+	}
+	assert.PanicsWithError(t, "goclub/sql: table note scopes: missing tenant id", func() {
+		sq.QB{
+			Table: TableNote{},
+		}.SQLSelect()
+	})
+	assert.PanicsWithError(t, "goclub/sql: table note scopes: missing tenant id", func() {
+		sq.QB{
+			Table: User{},
+			Join: []sq.Join{
+				{Type: sq.LeftJoin, Table: TableNote{}, On: "`note`.`body` = `user`.`name`"},
+			},
+		}.SQLSelect()
+	})
+}
 func (suite TestQBSuite) TestUnionTable() {
 	t := suite.T()
 	{
@@ -786,4 +868,240 @@ func (suite TestQBSuite) TestHaving() {
 	raw := qb.SQLSelect()
 	assert.Equal(t, "SELECT `name`, count(*) AS count FROM `user` WHERE `deleted_at` IS NULL GROUP BY `name` HAVING `count` > ?", raw.Query)
 	assert.Equal(t, []interface{}{1}, raw.Values)
-}
\ No newline at end of file
+}
+func (suite TestQBSuite) TestDialect() {
+	t := suite.T()
+	{
+		qb := sq.QB{
+			Table: User{},
+			Where: sq.And("name", sq.Equal("nimo")).And("age", sq.In([]int{1, 2})),
+			Limit: 10,
+			Offset: 20,
+			Lock: sq.FORUPDATE,
+			Dialect: sq.PostgreSQLDialect{},
+		}
+		raw := qb.SQLSelect()
+		assert.Equal(t, `SELECT "id", "name", "age", "created_at", "updated_at" FROM "user" WHERE "name" = $1 AND "age" IN ($2, $3) AND "deleted_at" IS NULL LIMIT $4 OFFSET $5 FOR UPDATE`, raw.Query)
+		assert.Equal(t, []interface{}{"nimo", 1, 2, 10, 20}, raw.Values)
+	}
+	{
+		qb := sq.QB{
+			Table: User{},
+			Select: []sq.Column{"user.id"},
+			WhereRaw: sq.Raw{"`name` = '?' AND `age` = ?", []interface{}{1}},
+			Dialect: sq.PostgreSQLDialect{},
+		}
+		raw := qb.SQLSelect()
+		assert.Equal(t, `SELECT "user"."id" AS "user.id" FROM "user" WHERE "name" = '?' AND "age" = $1 AND "deleted_at" IS NULL`, raw.Query)
+		assert.Equal(t, []interface{}{1}, raw.Values)
+	}
+	{
+		qb := sq.QB{
+			Table: User{},
+			Where: sq.And("id", sq.Equal(1)),
+			Update: []sq.Update{sq.Set("name", "nimo")},
+			Dialect: sq.PostgreSQLDialect{},
+		}
+		raw := qb.SQLUpdate()
+		assert.Equal(t, `UPDATE "user" SET "name"=$1 WHERE "id" = $2 AND "deleted_at" IS NULL`, raw.Query)
+		assert.Equal(t, []interface{}{"nimo", 1}, raw.Values)
+		// postgres sqlite 的 UPDATE DELETE 不支持 LIMIT, 不能忽略 QB.Limit
+		qb.Limit = 1
+		assert.PanicsWithError(t, "goclub/sql: postgres UPDATE not support LIMIT OFFSET, remove QB.Limit QB.Offset", func() {
+			qb.SQLUpdate()
+		})
+		qb.Dialect = sq.SQLiteDialect{}
+		assert.PanicsWithError(t, "goclub/sql: sqlite3 DELETE not support LIMIT OFFSET, remove QB.Limit QB.Offset", func() {
+			qb.SQLDelete()
+		})
+	}
+	{
+		qb := sq.QB{
+			Table: User{},
+			Offset: 20,
+			Dialect: sq.SQLiteDialect{},
+		}
+		raw := qb.SQLSelect()
+		assert.Equal(t, `SELECT "id", "name", "age", "created_at", "updated_at" FROM "user" WHERE "deleted_at" IS NULL LIMIT -1 OFFSET ?`, raw.Query)
+		assert.Equal(t, []interface{}{20}, raw.Values)
+		// sqlite 不支持行锁, 不能忽略 QB.Lock
+		qb.Lock = sq.FORUPDATE
+		assert.PanicsWithError(t, "goclub/sql: sqlite3 not support SELECT FOR UPDATE, remove QB.Lock", func() {
+			qb.SQLSelect()
+		})
+	}
+	{
+		qb := sq.QB{
+			Table: User{},
+			SoftDeleteMode: sq.WithTrashed,
+			Select: []sq.Column{"user.id"},
+			Join: []sq.Join{
+				{Type: sq.FullOuterJoin, TableName: "user_address", On: `"user_address"."user_id" = "user"."id"`},
+			},
+			Dialect: sq.PostgreSQLDialect{},
+		}
+		raw := qb.SQLSelect()
+		assert.Equal(t, `SELECT "user"."id" AS "user.id" FROM "user" FULL OUTER JOIN "user_address" ON "user_address"."user_id" = "user"."id"`, raw.Query)
+		qb.Dialect = nil
+		assert.PanicsWithError(t, "goclub/sql: mysql not support FULL OUTER JOIN, use UNION of LEFT JOIN and RIGHT JOIN", func() {
+			qb.SQLSelect()
+		})
+	}
+	{
+		qb := sq.QB{
+			Table: User{},
+			Limit: 1,
+			Lock: sq.FORUPDATE,
+		}
+		raw := qb.SQLSelect()
+		assert.Equal(t, "SELECT `id`, `name`, `age`, `created_at`, `updated_at` FROM `user` WHERE `deleted_at` IS NULL LIMIT ? FOR UPDATE", raw.Query)
+		assert.Equal(t, []interface{}{1}, raw.Values)
+	}
+	assert.Equal(t, sq.PostgreSQLDialect{}, sq.DialectByDriverName("postgres"))
+	assert.Equal(t, sq.SQLiteDialect{}, sq.DialectByDriverName("sqlite3"))
+	assert.Equal(t, sq.MySQLDialect{}, sq.DialectByDriverName("mysql"))
+}
+func (suite TestQBSuite) TestInsertMultiple() {
+	t := suite.T()
+	qb := sq.QB{
+		Table: User{},
+		InsertMultiple: sq.InsertMultiple{
+			Column: []sq.Column{"name", "age"},
+			Values: [][]interface{}{
+				{"nimo", 18},
+				{"tim", 20},
+			},
+		},
+	}
+	raw := qb.SQLInsert()
+	assert.Equal(t, "INSERT INTO `user` (`name`,`age`) VALUES (?,?),(?,?)", raw.Query)
+	assert.Equal(t, []interface{}{"nimo", 18, "tim", 20}, raw.Values)
+}
+func (suite TestQBSuite) TestUpsert() {
+	t := suite.T()
+	{
+		qb := sq.QB{
+			Table: User{},
+			Insert: []sq.Insert{sq.Value("id", 1), sq.Value("name", "nimo")},
+			OnDuplicateKeyUpdate: []sq.Update{sq.SetInsertValue("name"), sq.Set("age", 18)},
+		}
+		raw := qb.SQLInsert()
+		assert.Equal(t, "INSERT INTO `user` (`id`,`name`) VALUES (?,?) ON DUPLICATE KEY UPDATE `name` = VALUES(`name`),`age`=?", raw.Query)
+		assert.Equal(t, []interface{}{1, "nimo", 18}, raw.Values)
+	}
+	{
+		qb := sq.QB{
+			Table: User{},
+			Insert: []sq.Insert{sq.Value("id", 1)},
+			InsertIgnore: true,
+		}
+		raw := qb.SQLInsert()
+		assert.Equal(t, "INSERT IGNORE INTO `user` (`id`) VALUES (?)", raw.Query)
+	}
+	{
+		qb := sq.QB{
+			Table: User{},
+			Insert: []sq.Insert{sq.Value("id", 1)},
+			Replace: true,
+		}
+		raw := qb.SQLInsert()
+		assert.Equal(t, "REPLACE INTO `user` (`id`) VALUES (?)", raw.Query)
+	}
+	{
+		qb := sq.QB{
+			Table: User{},
+			Insert: []sq.Insert{sq.Value("id", 1), sq.Value("name", "nimo")},
+			OnDuplicateKeyUpdate: []sq.Update{sq.SetInsertValue("name"), sq.Set("age", 18)},
+			ConflictColumn: []sq.Column{"id"},
+			Dialect: sq.PostgreSQLDialect{},
+		}
+		raw := qb.SQLInsert()
+		assert.Equal(t, `INSERT INTO "user" ("id","name") VALUES ($1,$2) ON CONFLICT ("id") DO UPDATE SET "name" = EXCLUDED."name","age"=$3`, raw.Query)
+		assert.Equal(t, []interface{}{1, "nimo", 18}, raw.Values)
+	}
+	{
+		qb := sq.QB{
+			Table: User{},
+			Insert: []sq.Insert{sq.Value("id", 1)},
+			InsertIgnore: true,
+			Dialect: sq.PostgreSQLDialect{},
+		}
+		raw := qb.SQLInsert()
+		assert.Equal(t, `INSERT INTO "user" ("id") VALUES ($1) ON CONFLICT DO NOTHING`, raw.Query)
+	}
+}
+func (suite TestQBSuite) TestReturning() {
+	t := suite.T()
+	qb := sq.QB{
+		Table: Log{},
+		Insert: []sq.Insert{sq.Value("message", "nimo")},
+		Returning: []sq.Column{"id"},
+		Dialect: sq.PostgreSQLDialect{},
+	}
+	raw := qb.SQLInsert()
+	assert.Equal(t, `INSERT INTO "log" ("message") VALUES ($1) RETURNING "id"`, raw.Query)
+	assert.Equal(t, []interface{}{"nimo"}, raw.Values)
+}
+func (suite TestQBSuite) TestKeyset() {
+	t := suite.T()
+	{
+		qb, err := sq.QB{
+			Table: User{},
+			OrderBy: []sq.OrderBy{{"created_at", sq.ASC}, {"id", sq.ASC}},
+		}.Keyset("", 10)
+		assert.NoError(t, err)
+		raw := qb.SQLSelect()
+		assert.Equal(t, "SELECT `id`, `name`, `age`, `created_at`, `updated_at` FROM `user` WHERE `deleted_at` IS NULL ORDER BY `created_at` ASC, `id` ASC LIMIT ?", raw.Query)
+		assert.Equal(t, []interface{}{10}, raw.Values)
+	}
+	{
+		_, err := sq.QB{
+			Table: User{},
+			OrderBy: []sq.OrderBy{{"created_at", sq.ASC}, {"id", sq.DESC}},
+		}.Keyset("", 10)
+		assert.EqualError(t, err, "goclub/sql: keyset paging qb.OrderBy must have same ASC or DESC")
+	}
+	{
+		_, err := sq.QB{
+			Table: User{},
+			OrderBy: []sq.OrderBy{{"id", sq.ASC}},
+		}.Keyset("invalid", 10)
+		assert.EqualError(t, err, "goclub/sql: keyset cursor is invalid")
+	}
+}
+func (suite TestQBSuite) TestShard() {
+	t := suite.T()
+	{
+		qb := sq.QB{
+			Table: TableOrder{},
+			Where: sq.And("user_id", sq.Equal(uint64(5))),
+		}
+		raw := qb.SQLSelect()
+		assert.Equal(t, "SELECT * FROM `order_01` WHERE `user_id` = ?", raw.Query)
+		assert.Equal(t, []interface{}{uint64(5)}, raw.Values)
+	}
+	{
+		qb := sq.QB{
+			Table: TableOrder{},
+			Insert: []sq.Insert{sq.Value("user_id", uint64(2)), sq.Value("amount", 10)},
+		}
+		raw := qb.SQLInsert()
+		assert.Equal(t, "INSERT INTO `order_02` (`user_id`,`amount`) VALUES (?,?)", raw.Query)
+	}
+	{
+		order := &Order{ID: 1, UserID: 7}
+		qb := sq.QB{
+			Table: order,
+			Update: []sq.Update{{Column: "amount", Value: 1}},
+			Where: sq.And("id", sq.Equal(order.ID)),
+		}
+		raw := qb.SQLUpdate()
+		assert.Equal(t, "UPDATE `order_03` SET `amount`=? WHERE `id` = ?", raw.Query)
+	}
+	assert.PanicsWithError(t, sq.ErrMissingShardKey.Error(), func() {
+		sq.QB{Table: TableOrder{}}.SQLSelect()
+	})
+	assert.PanicsWithError(t, "goclub/sql: order shard key matched multiple shards, use Sharding", func() {
+		sq.QB{Table: TableOrder{}, Where: sq.And("user_id", sq.In([]uint64{1, 2}))}.SQLSelect()
+	})
+}
@@ -0,0 +1,92 @@
+package sq
+
+import (
+	"context"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"sync/atomic"
+)
+
+// 从库配置
+type Replica struct {
+	DataSourceName string
+	// 权重, 为 0 时视为 1, 所有从库权重相同时为轮询
+	Weight int
+}
+type replicaSet struct {
+	// 通过原子操作累加, 放在结构体开头以保证 32 位系统下的 64 位对齐
+	counter uint64
+	cores []*sqlx.DB
+	weights []int
+	totalWeight uint64
+}
+// 按照权重轮询选择从库
+func (set *replicaSet) next() *sqlx.DB {
+	n := (atomic.AddUint64(&set.counter, 1) - 1) % set.totalWeight
+	for i, weight := range set.weights {
+		if n < uint64(weight) {
+			return set.cores[i]
+		}
+		n -= uint64(weight)
+	}
+	return set.cores[len(set.cores)-1]
+}
+
+// 打开一个主库和多个从库, QueryStruct QuerySlice QueryRelation QueryRelationSlice QueryIterator Count Has Sum 会在从库执行
+// 其他操作, 带有 QB.Lock 的查询和 Transaction 中的所有操作都在主库执行
+// 写入后需要立即读取时可以使用 sq.ForcePrimary(ctx) 避免主从延迟
+func OpenCluster(driverName string, primaryDataSourceName string, replicas []Replica) (db *Database, dbClose func() error, err error) {
+	if len(replicas) == 0 {
+		return nil, func() error { return nil }, errors.New("goclub/sql: OpenCluster(driverName, primaryDataSourceName, replicas) replicas can not be empty")
+	}
+	db, dbClose, err = Open(driverName, primaryDataSourceName) ; if err != nil {
+		return
+	}
+	set := &replicaSet{}
+	for _, replica := range replicas {
+		var core *sqlx.DB
+		core, err = sqlx.Open(driverName, replica.DataSourceName) ; if err != nil {
+			for _, opened := range set.cores {
+				_ = opened.Close()
+			}
+			_ = db.Core.Close()
+			return nil, func() error { return nil }, err
+		}
+		weight := replica.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		set.cores = append(set.cores, core)
+		set.weights = append(set.weights, weight)
+		set.totalWeight += uint64(weight)
+	}
+	db.replicas = set
+	dbClose = db.Close
+	return
+}
+
+type forcePrimaryKey struct{}
+// 在主库执行查询, 用于写入后立即读取的场景
+// 	db.QueryStruct(sq.ForcePrimary(ctx), &user, qb)
+func ForcePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forcePrimaryKey{}, true)
+}
+type replicaReadKey struct{}
+// 标记查询可以在从库执行, 带有锁的查询必须在主库执行
+func replicaRead(ctx context.Context, qb QB) context.Context {
+	if len(qb.Lock) != 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, replicaReadKey{}, true)
+}
+// 慢查询记录本次查询选择的从库, 以便在同一个从库执行 EXPLAIN
+type readCoreKey struct{}
+func (db *Database) getReadCore(ctx context.Context) (core StoragerCore) {
+	if db.replicas == nil || ctx.Value(replicaReadKey{}) == nil || ctx.Value(forcePrimaryKey{}) != nil {
+		return db.Core
+	}
+	if readCore, ok := ctx.Value(readCoreKey{}).(*sqlx.DB); ok {
+		return readCore
+	}
+	return db.replicas.next()
+}
@@ -0,0 +1,226 @@
+package sq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+)
+
+// 作用域条件, 例如多租户的 tenant_id
+type Scope struct {
+	Column Column
+	Value interface{}
+}
+// 可选, Table 实现后 SELECT UPDATE DELETE 会自动增加 `column` = ? 条件, InsertModel UpsertModel InsertModels 会自动填充字段
+// ctx 中缺少作用域的值时应当返回错误, 避免查询到其他租户的数据. 通过 Database Transaction 执行时会返回该错误, 直接调用 QB.SQL() 时 panic
+// 子查询和 UNION 中的表会使用外层 QB 的 ctx, QB.Join 的表需要通过 Join.Table 设置才会在 ON 中增加作用域条件
+// OnDuplicateKeyUpdate 只会更新作用域内的数据, 不能使用 QB.Raw QB.Replace. QB.TableRaw 只替换表名, 作用域条件仍然来自 QB.Table
+// 	func (TableOrder) Scopes(ctx context.Context) ([]sq.Scope, error) {
+// 		tenantID, ok := ctx.Value(tenantKey{}).(uint64) ; if !ok {
+// 			return nil, errors.New("missing tenant id")
+// 		}
+// 		return []sq.Scope{{"tenant_id", tenantID}}, nil
+// 	}
+type ScopeTabler interface {
+	Scopes(ctx context.Context) ([]Scope, error)
+}
+
+type withoutScopesKey struct{}
+// 跨作用域查询(例如管理后台查询所有租户的数据)时使用, 会通过 Logger 记录 reason 和调用位置
+// 	ctx = db.WithoutScopes(ctx, "admin export orders")
+func (db *Database) WithoutScopes(ctx context.Context, reason string) context.Context {
+	event := LogEvent{
+		Query: "WithoutScopes: " + reason,
+		Rows: -1,
+		Caller: caller(),
+	}
+	if db.logger == nil {
+		log.Print("goclub/sql: " + event.Query + " caller:" + event.Caller)
+	} else {
+		db.logger.Log(ctx, event)
+	}
+	return context.WithValue(ctx, withoutScopesKey{}, reason)
+}
+// 与 SubQuery 相同, 使用 ctx 代替外层 QB 的 ctx 读取子查询的作用域
+func SubQueryContext(ctx context.Context, symbol string, qb QB) OP {
+	qb.scopeCtx = ctx
+	return SubQuery(symbol, qb)
+}
+
+func scopesOf(ctx context.Context, tabler Tabler) (scopes []Scope, err error) {
+	scopeTabler, ok := tabler.(ScopeTabler)
+	if t, isRelation := tabler.(table); isRelation {
+		scopeTabler, ok = t.scopeTabler, t.scopeTabler != nil
+	}
+	if !ok {
+		return
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if ctx.Value(withoutScopesKey{}) != nil {
+		return
+	}
+	return scopeTabler.Scopes(ctx)
+}
+// 通过 Database Transaction 执行时在生成 SQL 之前读取作用域, ctx 中缺少作用域的值时返回错误
+func (qb QB) resolveScopes() (QB, error) {
+	if qb.scopeResolved {
+		return qb, nil
+	}
+	conditions, err := scopeConditionsOf(qb.scopeCtx, qb.Table) ; if err != nil {
+		return qb, err
+	}
+	qb.scopes, qb.scopeResolved = conditions, true
+	if len(qb.Join) != 0 {
+		joins := make([]Join, len(qb.Join))
+		for i, join := range qb.Join {
+			join.scopes, err = join.resolveScopes(qb.scopeCtx) ; if err != nil {
+				return qb, err
+			}
+			join.scopeResolved = true
+			joins[i] = join
+		}
+		qb.Join = joins
+	}
+	return qb, nil
+}
+// 检查无法增加作用域条件的用法, 避免读取或修改其他作用域的数据
+func (qb QB) checkScopes(statement Statement) error {
+	if len(qb.scopes) == 0 {
+		return nil
+	}
+	if len(qb.Raw.Query) != 0 {
+		return errors.New("goclub/sql: table " + qb.Table.TableName() + " has scopes, QB.Raw can not add scope conditions, use QB.Where or db.WithoutScopes")
+	}
+	if statement == statement.Enum().Insert {
+		err := qb.checkReplaceScopes() ; if err != nil {
+			return err
+		}
+		_, err = qb.scopeConflictUpdates(coalesceDialect(qb.Dialect)) ; if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+// REPLACE INTO 会删除主键冲突的其他作用域的数据
+func (qb QB) checkReplaceScopes() error {
+	if !qb.Replace || len(qb.scopeConditions()) == 0 {
+		return nil
+	}
+	return errors.New("goclub/sql: table " + qb.Table.TableName() + " has scopes, can not use QB.Replace, use QB.OnDuplicateKeyUpdate")
+}
+// 主键冲突的数据属于其他作用域时 ON DUPLICATE KEY UPDATE ON CONFLICT DO UPDATE 不修改任何字段
+// `body` = CASE WHEN `note`.`tenant_id` = ? THEN VALUES(`body`) ELSE `note`.`body` END
+func (qb QB) scopeConflictUpdates(dialect Dialect) ([]Update, error) {
+	if len(qb.OnDuplicateKeyUpdate) == 0 {
+		return qb.OnDuplicateKeyUpdate, nil
+	}
+	scopes := qb.scopeConditions()
+	if len(scopes) == 0 {
+		return qb.OnDuplicateKeyUpdate, nil
+	}
+	tableName := qb.Table.TableName() + qb.shardTableSuffix(Statement("").Enum().Insert)
+	scopeRaw := ToConditions(qualifyScopes(tableName, scopes)).coreSQL("AND")
+	updates := make([]Update, len(qb.OnDuplicateKeyUpdate))
+	for i, data := range qb.OnDuplicateKeyUpdate {
+		if len(data.Raw.Query) != 0 {
+			return nil, errors.New("goclub/sql: table " + qb.Table.TableName() + " has scopes, OnDuplicateKeyUpdate can not use Update.Raw, use sq.Set or sq.SetInsertValue")
+		}
+		value := Raw{"?", []interface{}{data.Value}}
+		if data.insertValue {
+			value = Raw{dialect.InsertValue(data.Column.wrapField()), nil}
+		}
+		field := data.Column.wrapField()
+		updates[i] = Update{Raw: Raw{
+			field + " = CASE WHEN " + scopeRaw.Query + " THEN " + value.Query + " ELSE " + Column(tableName + "." + data.Column.String()).wrapField() + " END",
+			append(append([]interface{}{}, scopeRaw.Values...), value.Values...),
+		}}
+	}
+	return updates, nil
+}
+// `tenant_id` = ? 转换为 `note`.`tenant_id` = ?
+func qualifyScopes(tableName string, scopes []Condition) []Condition {
+	qualified := make([]Condition, len(scopes))
+	for i, scope := range scopes {
+		qualified[i] = Condition{Column(tableName + "." + scope.Column.String()), scope.OP}
+	}
+	return qualified
+}
+func (join Join) resolveScopes(ctx context.Context) ([]Condition, error) {
+	if join.Table == nil {
+		return nil, nil
+	}
+	conditions, err := scopeConditionsOf(ctx, join.Table) ; if err != nil {
+		return nil, err
+	}
+	return qualifyScopes(join.Table.TableName(), conditions), nil
+}
+// 与 QB.scopeConditions 相同, 直接调用 QB.SQL() 时 ctx 中缺少作用域的值时 panic
+func (join Join) scopeConditions(ctx context.Context) []Condition {
+	if join.scopeResolved {
+		return join.scopes
+	}
+	conditions, err := join.resolveScopes(ctx) ; if err != nil {
+		panic(err)
+	}
+	return conditions
+}
+// 作用域条件, 直接调用 QB.SQL() 时无法返回错误, ctx 中缺少作用域的值时 panic
+func (qb QB) scopeConditions() []Condition {
+	if qb.scopeResolved {
+		return qb.scopes
+	}
+	conditions, err := scopeConditionsOf(qb.scopeCtx, qb.Table) ; if err != nil {
+		panic(err)
+	}
+	return conditions
+}
+func scopeConditionsOf(ctx context.Context, tabler Tabler) (conditions []Condition, err error) {
+	if tabler == nil {
+		return
+	}
+	scopes, err := scopesOf(ctx, tabler) ; if err != nil {
+		return nil, errors.New("goclub/sql: table " + tabler.TableName() + " scopes: " + err.Error())
+	}
+	for _, scope := range scopes {
+		conditions = append(conditions, Condition{scope.Column, Equal(scope.Value)})
+	}
+	return
+}
+// 插入前填充作用域字段, 字段已经有值且与作用域不同时返回错误
+func stampScopes(ctx context.Context, ptr Model) (err error) {
+	scopes, err := scopesOf(ctx, ptr) ; if err != nil {
+		return
+	}
+	for _, scope := range scopes {
+		fieldValue, has := fieldValueByColumn(reflect.ValueOf(ptr), scope.Column) ; if !has {
+			return errors.New("goclub/sql: " + reflect.TypeOf(ptr).String() + " has no scope field `db:\"" + scope.Column.String() + "\"`")
+		}
+		scopeValue := reflect.ValueOf(scope.Value)
+		if !scopeValue.IsValid() || !canConvertScope(scopeValue.Type(), fieldValue.Type()) {
+			return errors.New("goclub/sql: scope `" + scope.Column.String() + "` value " + fmt.Sprintf("%T", scope.Value) + " can not convert to " + reflect.TypeOf(ptr).String() + " field type " + fieldValue.Type().String())
+		}
+		scopeValue = scopeValue.Convert(fieldValue.Type())
+		if fieldValue.IsZero() {
+			fieldValue.Set(scopeValue)
+			continue
+		}
+		if !reflect.DeepEqual(fieldValue.Interface(), scopeValue.Interface()) {
+			return errors.New("goclub/sql: " + reflect.TypeOf(ptr).String() + " field `" + scope.Column.String() + "` does not match scope")
+		}
+	}
+	return
+}
+// 整数可以 Convert 为 string 但结果是字符而不是数字, 需要排除
+func canConvertScope(from reflect.Type, to reflect.Type) bool {
+	if !from.ConvertibleTo(to) {
+		return false
+	}
+	if to.Kind() == reflect.String && from.Kind() != reflect.String {
+		return false
+	}
+	return true
+}
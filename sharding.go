@@ -0,0 +1,488 @@
+package sq
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 分片, Database 为 Sharding.Databases 的序号, 实际表名为 TableName() + TableSuffix
+type Shard struct {
+	Database int
+	TableSuffix string
+}
+// 分片规则
+// 	func (TableOrder) ShardRule() sq.ShardRule {
+// 		return sq.ShardRule{
+// 			Column: "user_id",
+// 			Route: func(value interface{}) (sq.Shard, error) {
+// 				userID, err := strconv.ParseUint(fmt.Sprint(value), 10, 64) ; if err != nil {...}
+// 				return sq.Shard{Database: int(userID % 4 / 2), TableSuffix: fmt.Sprintf("_%02d", userID % 4)}, nil
+// 			},
+// 			Shards: []sq.Shard{{0, "_00"}, {0, "_01"}, {1, "_02"}, {1, "_03"}},
+// 		}
+// 	}
+type ShardRule struct {
+	// 分片键
+	Column Column
+	// 根据分片键的值计算分片, value 为 WHERE 或 Model 中分片键的值
+	Route func(value interface{}) (shard Shard, err error)
+	// 所有分片, 设置了 QB.ShardFanOut 且 WHERE 中不包含分片键时会在所有分片中执行
+	Shards []Shard
+}
+// 实现了 ShardTabler 的表会根据分片键自动计算表名
+type ShardTabler interface {
+	Tabler
+	ShardRule() ShardRule
+}
+var ErrMissingShardKey = errors.New("goclub/sql: sharding table WHERE must contain shard key, or set QB.ShardFanOut and use Sharding")
+
+type shardDatabaseKey struct{}
+// 通过 Database Transaction 执行时在生成 SQL 之前计算分片, 无法确定唯一分片时返回错误
+// Database Transaction 不知道自己是第几个分片数据库, 所以直接执行时分片必须在 Shard{Database: 0} 中, 其他分片数据库需要通过 Sharding 执行
+func (qb QB) resolveShard(statement Statement) (QB, error) {
+	shardTabler, ok := qb.Table.(ShardTabler) ; if !ok || qb.shard != nil {
+		return qb, nil
+	}
+	shard, err := qb.uniqueShard(shardTabler, statement) ; if err != nil {
+		return qb, err
+	}
+	database := 0
+	if qb.scopeCtx != nil {
+		if index, has := qb.scopeCtx.Value(shardDatabaseKey{}).(int); has {
+			database = index
+		}
+	}
+	if shard.Database != database {
+		return qb, errors.New("goclub/sql: " + shardTabler.TableName() + shard.TableSuffix + " is in shard database " + strconv.Itoa(shard.Database) + " but executed on shard database " + strconv.Itoa(database) + ", use Sharding")
+	}
+	qb.shard = &shard
+	return qb, nil
+}
+func (qb QB) uniqueShard(shardTabler ShardTabler, statement Statement) (shard Shard, err error) {
+	shards, err := qb.shards(shardTabler.ShardRule(), statement) ; if err != nil {
+		return
+	}
+	if len(shards) == 0 {
+		return shard, ErrMissingShardKey
+	}
+	if len(shards) > 1 {
+		return shard, errors.New("goclub/sql: " + shardTabler.TableName() + " shard key matched multiple shards, use Sharding")
+	}
+	return shards[0], nil
+}
+// 计算 QB 对应的表名后缀, 直接调用 QB.SQL() 时无法返回错误, 无法确定唯一分片时 panic
+func (qb QB) shardTableSuffix(statement Statement) string {
+	shardTabler, ok := qb.Table.(ShardTabler) ; if !ok {
+		return ""
+	}
+	if qb.shard != nil {
+		return qb.shard.TableSuffix
+	}
+	shard, err := qb.uniqueShard(shardTabler, statement) ; if err != nil {
+		panic(err)
+	}
+	return shard.TableSuffix
+}
+// 根据分片键的值计算分片并去重, 不包含分片键时返回空
+func (qb QB) shards(rule ShardRule, statement Statement) (shards []Shard, err error) {
+	if rule.Route == nil {
+		return nil, errors.New("goclub/sql: ShardRule.Route can not be nil")
+	}
+	exist := map[Shard]bool{}
+	for _, value := range qb.shardKeyValues(rule.Column, statement) {
+		var shard Shard
+		shard, err = rule.Route(value) ; if err != nil {
+			return
+		}
+		if !exist[shard] {
+			exist[shard] = true
+			shards = append(shards, shard)
+		}
+	}
+	return
+}
+// 依次从 WHERE, INSERT, Model 字段中查找分片键的值
+// 查询时 Table 通常是用于接收数据的结构体, 所以 SELECT 不会读取 Model 字段
+func (qb QB) shardKeyValues(column Column, statement Statement) (values []interface{}) {
+	if values = conditionsShardKeyValues(qb.Where, column); len(values) != 0 {
+		return
+	}
+	if len(qb.WhereOR) != 0 {
+		for _, where := range qb.WhereOR {
+			groupValues := conditionsShardKeyValues(where, column)
+			// 任意一组条件不包含分片键都需要查询所有分片
+			if len(groupValues) == 0 {
+				return nil
+			}
+			values = append(values, groupValues...)
+		}
+		return
+	}
+	for _, insert := range qb.Insert {
+		if insert.Column == column {
+			return []interface{}{insert.Value}
+		}
+	}
+	for i, insertColumn := range qb.InsertMultiple.Column {
+		if insertColumn == column {
+			for _, row := range qb.InsertMultiple.Values {
+				values = append(values, row[i])
+			}
+			return
+		}
+	}
+	if statement != statement.Enum().Select && qb.Table != nil {
+		rValue := reflect.ValueOf(qb.Table)
+		if rValue.Kind() == reflect.Ptr && !rValue.IsNil() && rValue.Elem().Kind() == reflect.Struct {
+			fieldValue, has := fieldValueByColumn(rValue, column)
+			if has {
+				return []interface{}{fieldValue.Interface()}
+			}
+		}
+	}
+	return nil
+}
+// 只识别 AND 条件中的 sq.Equal 和 sq.In, 子查询等带有 Placeholder 的条件的 Values 不是分片键的值
+func conditionsShardKeyValues(conditions []Condition, column Column) (values []interface{}) {
+	for _, condition := range conditions {
+		op := condition.OP
+		if condition.Column != column || op.Ignore || op.Query != "" {
+			continue
+		}
+		if op.Symbol == "=" && op.Placeholder == "" && op.subQuery == nil {
+			return op.Values
+		}
+		if op.Symbol == "IN" && op.inList {
+			return op.Values
+		}
+	}
+	return nil
+}
+
+// 管理多个分片数据库, 根据 ShardTabler 的 ShardRule 将操作路由到对应的数据库和表
+// 	sharding := sq.NewSharding(db0, db1)
+// 	err := sharding.QuerySlice(ctx, &orders, sq.QB{Where: sq.And("user_id", sq.Equal(userID))})
+type Sharding struct {
+	Databases []*Database
+}
+func NewSharding(databases ...*Database) *Sharding {
+	return &Sharding{Databases: databases}
+}
+type shardTarget struct {
+	db *Database
+	shard Shard
+}
+// 计算需要执行的分片, WHERE 中不包含分片键时根据 QB.ShardFanOut 返回所有分片或 ErrMissingShardKey
+func (s *Sharding) targets(qb QB, table Tabler, statement Statement) (targets []shardTarget, err error) {
+	if table == nil {
+		return nil, errors.New("goclub/sql: Sharding qb.Table can not be nil")
+	}
+	shardTabler, ok := table.(ShardTabler) ; if !ok {
+		return nil, errors.New("goclub/sql: Sharding " + reflect.TypeOf(table).String() + " must implement sq.ShardTabler")
+	}
+	rule := shardTabler.ShardRule()
+	qb.Table = table
+	shards, err := qb.shards(rule, statement) ; if err != nil {
+		return
+	}
+	if len(shards) == 0 {
+		if !qb.ShardFanOut {
+			return nil, ErrMissingShardKey
+		}
+		shards = rule.Shards
+		if len(shards) == 0 {
+			return nil, errors.New("goclub/sql: QB.ShardFanOut must set ShardRule.Shards")
+		}
+	}
+	for _, shard := range shards {
+		if shard.Database < 0 || shard.Database >= len(s.Databases) {
+			return nil, errors.New("goclub/sql: shard database index " + strconv.Itoa(shard.Database) + " out of range")
+		}
+		targets = append(targets, shardTarget{db: s.Databases[shard.Database], shard: shard})
+	}
+	return
+}
+func (s *Sharding) target(qb QB, table Tabler, statement Statement) (target shardTarget, err error) {
+	targets, err := s.targets(qb, table, statement) ; if err != nil {
+		return
+	}
+	if len(targets) != 1 {
+		return target, errors.New("goclub/sql: " + table.TableName() + " shard key matched multiple shards")
+	}
+	return targets[0], nil
+}
+func (target shardTarget) qb(qb QB) QB {
+	shard := target.shard
+	qb.shard = &shard
+	return qb
+}
+// 标记当前执行的分片数据库, Model 的分片在 Database 中根据字段重新计算, 需要与 Sharding 选择的数据库一致
+func (target shardTarget) ctx(ctx context.Context) context.Context {
+	return context.WithValue(ctx, shardDatabaseKey{}, target.shard.Database)
+}
+// 分片数据库中每一组数据必须属于同一个分片, InsertMultiple 会按照分片拆分
+func (s *Sharding) Insert(ctx context.Context, qb QB) (result sql.Result, err error) {
+	if len(qb.InsertMultiple.Values) == 0 {
+		target, err := s.target(qb, qb.Table, Statement("").Enum().Insert) ; if err != nil {
+			return nil, err
+		}
+		return target.db.Insert(target.ctx(ctx), target.qb(qb))
+	}
+	var results batchResult
+	rows := qb.InsertMultiple.Values
+	var targets []shardTarget
+	targetRows := map[Shard][][]interface{}{}
+	for _, row := range rows {
+		rowQB := qb
+		rowQB.InsertMultiple.Values = [][]interface{}{row}
+		var target shardTarget
+		target, err = s.target(rowQB, qb.Table, Statement("").Enum().Insert) ; if err != nil {
+			return
+		}
+		if _, has := targetRows[target.shard]; !has {
+			targets = append(targets, target)
+		}
+		targetRows[target.shard] = append(targetRows[target.shard], row)
+	}
+	for _, target := range targets {
+		shardQB := target.qb(qb)
+		shardQB.InsertMultiple.Values = targetRows[target.shard]
+		var shardResult sql.Result
+		shardResult, err = target.db.Insert(target.ctx(ctx), shardQB) ; if err != nil {
+			return
+		}
+		results = append(results, shardResult)
+	}
+	return results, nil
+}
+func (s *Sharding) InsertModel(ctx context.Context, ptr Model, checkSQL ...string) (err error) {
+	target, err := s.modelTarget(ptr, Statement("").Enum().Insert) ; if err != nil {
+		return
+	}
+	return target.db.InsertModel(target.ctx(ctx), ptr, checkSQL...)
+}
+func (s *Sharding) UpdateModel(ctx context.Context, ptr Model, updateData []Update, where []Condition, checkSQL ...string) (result sql.Result, err error) {
+	target, err := s.modelTarget(ptr, Statement("").Enum().Update) ; if err != nil {
+		return
+	}
+	return target.db.UpdateModel(target.ctx(ctx), ptr, updateData, where, checkSQL...)
+}
+func (s *Sharding) HardDeleteModel(ctx context.Context, ptr Model, checkSQL ...string) (result sql.Result, err error) {
+	target, err := s.modelTarget(ptr, Statement("").Enum().Delete) ; if err != nil {
+		return
+	}
+	return target.db.HardDeleteModel(target.ctx(ctx), ptr, checkSQL...)
+}
+func (s *Sharding) SoftDeleteModel(ctx context.Context, ptr Model, checkSQL ...string) (result sql.Result, err error) {
+	target, err := s.modelTarget(ptr, Statement("").Enum().Update) ; if err != nil {
+		return
+	}
+	return target.db.SoftDeleteModel(target.ctx(ctx), ptr, checkSQL...)
+}
+// Model 通过字段中分片键的值路由
+func (s *Sharding) modelTarget(ptr Model, statement Statement) (target shardTarget, err error) {
+	return s.target(QB{}, ptr, statement)
+}
+// 查询多个分片时与 QuerySlice 相同, 合并后根据 qb.OrderBy 排序并返回第一条数据
+func (s *Sharding) QueryStruct(ctx context.Context, ptr Tabler, qb QB) (has bool, err error) {
+	rValue := reflect.ValueOf(ptr)
+	if rValue.Kind() != reflect.Ptr {
+		panic(errors.New("goclub/sql: " + rValue.Type().String() + "not pointer"))
+	}
+	table := qb.Table
+	if table == nil {
+		table = ptr
+	}
+	targets, err := s.targets(qb, table, Statement("").Enum().Select) ; if err != nil {
+		return
+	}
+	if len(targets) == 1 {
+		return targets[0].db.QueryStruct(targets[0].ctx(ctx), ptr, targets[0].qb(qb))
+	}
+	sliceQB := qb
+	sliceQB.Table = table
+	sliceQB.Limit = 1
+	slicePtr := reflect.New(reflect.SliceOf(rValue.Type().Elem()))
+	err = s.QuerySlice(ctx, slicePtr.Interface(), sliceQB) ; if err != nil {
+		return
+	}
+	if slicePtr.Elem().Len() == 0 {
+		return false, nil
+	}
+	rValue.Elem().Set(slicePtr.Elem().Index(0))
+	return true, nil
+}
+// 查询多个分片时会合并结果, 并根据 qb.OrderBy qb.Offset qb.Limit 在内存中排序和分页
+func (s *Sharding) QuerySlice(ctx context.Context, slicePtr interface{}, qb QB) (err error) {
+	ptrType := reflect.TypeOf(slicePtr)
+	if ptrType.Kind() != reflect.Ptr {
+		panic(errors.New("goclub/sql: " + ptrType.String() + "not pointer"))
+	}
+	table := qb.Table
+	if table == nil {
+		table = reflect.MakeSlice(ptrType.Elem(), 1, 1).Index(0).Interface().(Tabler)
+	}
+	targets, err := s.targets(qb, table, Statement("").Enum().Select) ; if err != nil {
+		return
+	}
+	if len(targets) == 1 {
+		return targets[0].db.QuerySlice(targets[0].ctx(ctx), slicePtr, targets[0].qb(qb))
+	}
+	shardQB := qb
+	if qb.Limit != 0 {
+		shardQB.Limit = qb.Limit + qb.Offset
+		shardQB.Offset = 0
+	}
+	merged := reflect.MakeSlice(ptrType.Elem(), 0, 0)
+	for _, target := range targets {
+		shardSlicePtr := reflect.New(ptrType.Elem())
+		err = target.db.QuerySlice(target.ctx(ctx), shardSlicePtr.Interface(), target.qb(shardQB)) ; if err != nil {
+			return
+		}
+		merged = reflect.AppendSlice(merged, shardSlicePtr.Elem())
+	}
+	if len(qb.OrderBy) != 0 {
+		sort.SliceStable(merged.Interface(), func(i, j int) bool {
+			return lessByOrderBy(merged.Index(i), merged.Index(j), qb.OrderBy)
+		})
+	}
+	if qb.Offset != 0 {
+		if qb.Offset >= merged.Len() {
+			merged = merged.Slice(0, 0)
+		} else {
+			merged = merged.Slice(qb.Offset, merged.Len())
+		}
+	}
+	if qb.Limit != 0 && merged.Len() > qb.Limit {
+		merged = merged.Slice(0, qb.Limit)
+	}
+	reflect.ValueOf(slicePtr).Elem().Set(merged)
+	return
+}
+// 查询多个分片时返回各分片数量之和, 同一个分组可能分布在多个分片中, 所以不支持 GroupBy 和 UnionTable
+func (s *Sharding) Count(ctx context.Context, qb QB) (count uint64, err error) {
+	targets, err := s.targets(qb, qb.Table, Statement("").Enum().Select) ; if err != nil {
+		return
+	}
+	if len(targets) > 1 && (len(qb.GroupBy) != 0 || len(qb.UnionTable.Tables) != 0) {
+		return 0, errors.New("goclub/sql: Sharding.Count can not use QB.GroupBy or QB.UnionTable on multiple shards")
+	}
+	for _, target := range targets {
+		var shardCount uint64
+		shardCount, err = target.db.Count(target.ctx(ctx), target.qb(qb)) ; if err != nil {
+			return
+		}
+		count += shardCount
+	}
+	return
+}
+// 更新多个分片时 result.RowsAffected() 为各分片影响行数之和
+func (s *Sharding) Update(ctx context.Context, qb QB) (result sql.Result, err error) {
+	return s.exec(ctx, qb, Statement("").Enum().Update, func(target shardTarget, qb QB) (sql.Result, error) {
+		return target.db.Update(target.ctx(ctx), qb)
+	})
+}
+func (s *Sharding) HardDelete(ctx context.Context, qb QB) (result sql.Result, err error) {
+	return s.exec(ctx, qb, Statement("").Enum().Delete, func(target shardTarget, qb QB) (sql.Result, error) {
+		return target.db.HardDelete(target.ctx(ctx), qb)
+	})
+}
+func (s *Sharding) SoftDelete(ctx context.Context, qb QB) (result sql.Result, err error) {
+	return s.exec(ctx, qb, Statement("").Enum().Update, func(target shardTarget, qb QB) (sql.Result, error) {
+		return target.db.SoftDelete(target.ctx(ctx), qb)
+	})
+}
+func (s *Sharding) exec(ctx context.Context, qb QB, statement Statement, handle func(target shardTarget, qb QB) (sql.Result, error)) (result sql.Result, err error) {
+	targets, err := s.targets(qb, qb.Table, statement) ; if err != nil {
+		return
+	}
+	var results batchResult
+	for _, target := range targets {
+		var shardResult sql.Result
+		shardResult, err = handle(target, target.qb(qb)) ; if err != nil {
+			return
+		}
+		results = append(results, shardResult)
+	}
+	return results, nil
+}
+
+// 根据 OrderBy 比较两条数据, 用于合并多个分片的查询结果
+func lessByOrderBy(a reflect.Value, b reflect.Value, orderBy []OrderBy) bool {
+	for _, order := range orderBy {
+		aValue, hasA := fieldValueByColumn(a, order.Column)
+		bValue, hasB := fieldValueByColumn(b, order.Column)
+		if !hasA || !hasB {
+			continue
+		}
+		result := compareValue(aValue.Interface(), bValue.Interface())
+		if result == 0 {
+			continue
+		}
+		if order.Type == DESC {
+			return result > 0
+		}
+		return result < 0
+	}
+	return false
+}
+func compareValue(a interface{}, b interface{}) int {
+	if valuer, ok := a.(driver.Valuer); ok {
+		a, _ = valuer.Value()
+	}
+	if valuer, ok := b.(driver.Valuer); ok {
+		b, _ = valuer.Value()
+	}
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+	if aTime, ok := a.(time.Time); ok {
+		bTime, _ := b.(time.Time)
+		switch {
+		case aTime.Before(bTime):
+			return -1
+		case aTime.After(bTime):
+			return 1
+		}
+		return 0
+	}
+	aValue, bValue := reflect.ValueOf(a), reflect.ValueOf(b)
+	if aValue.Kind() != bValue.Kind() {
+		return 0
+	}
+	switch aValue.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return compareOrdered(aValue.Int() < bValue.Int(), aValue.Int() > bValue.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return compareOrdered(aValue.Uint() < bValue.Uint(), aValue.Uint() > bValue.Uint())
+	case reflect.Float32, reflect.Float64:
+		return compareOrdered(aValue.Float() < bValue.Float(), aValue.Float() > bValue.Float())
+	case reflect.String:
+		return compareOrdered(aValue.String() < bValue.String(), aValue.String() > bValue.String())
+	case reflect.Bool:
+		return compareOrdered(!aValue.Bool() && bValue.Bool(), aValue.Bool() && !bValue.Bool())
+	}
+	return 0
+}
+func compareOrdered(less bool, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
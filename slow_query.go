@@ -0,0 +1,181 @@
+package sq
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"log"
+	"strings"
+	"time"
+)
+
+// 执行 EXPLAIN 的超时时间, EXPLAIN 不会执行 SQL, 正常情况下很快返回
+const slowQueryExplainTimeout = 5 * time.Second
+// 同时执行的 EXPLAIN 数量上限, 超过时跳过 EXPLAIN, 避免大量慢查询时占满连接池
+const slowQueryExplainWorkers = 4
+// 正在执行的 EXPLAIN 达到上限时 SlowQueryEvent.ExplainErr 为 ErrSlowQueryExplainSkipped
+var ErrSlowQueryExplainSkipped = errors.New("goclub/sql: too many slow query EXPLAIN running, skipped")
+
+// 慢查询事件, 只有 MySQL 会执行 EXPLAIN FORMAT=JSON, 其他数据库 Plan 为空
+type SlowQueryEvent struct {
+	LogEvent
+	// EXPLAIN FORMAT=JSON 的结果
+	Plan string
+	ExplainErr error
+	// 执行计划中存在 "access_type": "ALL"
+	FullTableScan bool
+	// 执行计划中存在 "using_filesort": true
+	UsingFilesort bool
+	// 执行计划中存在 "using_temporary_table": true
+	UsingTemporaryTable bool
+}
+type slowQuery struct {
+	threshold time.Duration
+	handle func(ctx context.Context, event SlowQueryEvent)
+	// EXPLAIN 通过连接池中的其他连接执行, 不会影响当前事务. 从库执行的查询在同一个从库 EXPLAIN
+	explainCore *sqlx.DB
+	// 容量为 slowQueryExplainWorkers 的信号量
+	explainWorkers chan struct{}
+}
+// SELECT INSERT UPDATE DELETE 执行时间超过 threshold 时会通过单独的连接执行 EXPLAIN FORMAT=JSON 并调用 handle
+// threshold 为 0 时关闭, handle 为 nil 时使用 log.Print 输出. 通过 db.Transaction 创建的事务会继承该配置
+// MySQL 的 EXPLAIN 和 handle 在单独的 goroutine 中执行, 不会阻塞当前查询, 此时 handle 的 ctx 可能已经结束, 只应用于读取 ctx 中的值
+// 	db.SetSlowQuery(time.Second, func(ctx context.Context, event sq.SlowQueryEvent) {
+// 		if event.FullTableScan {...}
+// 	})
+func (db *Database) SetSlowQuery(threshold time.Duration, handle func(ctx context.Context, event SlowQueryEvent)) {
+	if threshold <= 0 {
+		db.slowQuery = nil
+		return
+	}
+	if handle == nil {
+		handle = logSlowQuery
+	}
+	db.slowQuery = &slowQuery{
+		threshold: threshold,
+		handle: handle,
+		explainCore: db.Core,
+		explainWorkers: make(chan struct{}, slowQueryExplainWorkers),
+	}
+}
+func logSlowQuery(ctx context.Context, event SlowQueryEvent) {
+	var flags []string
+	if event.FullTableScan {
+		flags = append(flags, "full table scan")
+	}
+	if event.UsingFilesort {
+		flags = append(flags, "using filesort")
+	}
+	if event.UsingTemporaryTable {
+		flags = append(flags, "using temporary table")
+	}
+	log.Print("goclub/sql: slow query(", event.Duration, ") ", event.Query, " ", event.Values, " caller:", event.Caller, " ", strings.Join(flags, ","), "\n", event.Plan)
+}
+// 包裹 handle 在执行时间超过阈值时触发慢查询事件
+func slowQueryHandle(storager Storager, slow *slowQuery, handle InterceptorNext) InterceptorNext {
+	return func(ctx context.Context, query string, values []interface{}) (result sql.Result, err error) {
+		explainCore := slow.explainCore
+		// 固定本次查询使用的从库, 以便在执行查询的同一个从库 EXPLAIN
+		if statementOf(query) == Statement("").Enum().Select {
+			if readCore, ok := storager.getReadCore(ctx).(*sqlx.DB); ok {
+				explainCore = readCore
+				ctx = context.WithValue(ctx, readCoreKey{}, readCore)
+			}
+		}
+		startTime := time.Now()
+		result, err = handle(ctx, query, values)
+		duration := time.Since(startTime)
+		if duration < slow.threshold {
+			return
+		}
+		statement := statementOf(query)
+		enum := statement.Enum()
+		switch statement {
+		case enum.Select, enum.Insert, enum.Update, enum.Delete:
+		default:
+			return
+		}
+		event := SlowQueryEvent{
+			LogEvent: LogEvent{
+				Statement: statement,
+				Query: query,
+				Values: redactValues(values),
+				Duration: duration,
+				Rows: -1,
+				Err: err,
+				TxID: storager.getTxID(),
+				Caller: caller(),
+			},
+		}
+		if err == nil && result != nil {
+			rows, rowsErr := result.RowsAffected() ; if rowsErr == nil {
+				event.Rows = rows
+			}
+		}
+		if _, isMySQL := storager.getDialect().(MySQLDialect); !isMySQL {
+			slow.handle(ctx, event)
+			return
+		}
+		select {
+		case slow.explainWorkers <- struct{}{}:
+			go func() {
+				defer func() { <-slow.explainWorkers }()
+				event.Plan, event.ExplainErr = explain(explainCore, query, values)
+				if event.ExplainErr == nil {
+					event.FullTableScan, event.UsingFilesort, event.UsingTemporaryTable = explainFlags(event.Plan)
+				}
+				slow.handle(ctx, event)
+			}()
+		default:
+			event.ExplainErr = ErrSlowQueryExplainSkipped
+			slow.handle(ctx, event)
+		}
+		return
+	}
+}
+func explain(core *sqlx.DB, query string, values []interface{}) (plan string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), slowQueryExplainTimeout)
+	defer cancel()
+	err = core.QueryRowContext(ctx, "EXPLAIN FORMAT=JSON " + query, values...).Scan(&plan) ; if err != nil {
+		return
+	}
+	return
+}
+// 遍历 EXPLAIN FORMAT=JSON 的结果查找全表扫描 文件排序 临时表
+func explainFlags(plan string) (fullTableScan bool, usingFilesort bool, usingTemporaryTable bool) {
+	var data interface{}
+	err := json.Unmarshal([]byte(plan), &data) ; if err != nil {
+		return
+	}
+	var walk func(value interface{})
+	walk = func(value interface{}) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			for key, item := range v {
+				switch key {
+				case "access_type":
+					if item == "ALL" {
+						fullTableScan = true
+					}
+				case "using_filesort":
+					if item == true {
+						usingFilesort = true
+					}
+				case "using_temporary_table":
+					if item == true {
+						usingTemporaryTable = true
+					}
+				}
+				walk(item)
+			}
+		case []interface{}:
+			for _, item := range v {
+				walk(item)
+			}
+		}
+	}
+	walk(data)
+	return
+}
@@ -10,9 +10,24 @@ type Tag struct {
 	Value string
 }
 func (t Tag) IsIgnore() bool {
+	return t.has("ignore")
+}
+// `sq:"pk"`
+func (t Tag) IsPrimaryKey() bool {
+	return t.has("pk")
+}
+// `sq:"pk|autoincr"`
+func (t Tag) IsAutoIncrement() bool {
+	return t.has("autoincr")
+}
+// `sq:"version"`
+func (t Tag) IsVersion() bool {
+	return t.has("version")
+}
+func (t Tag) has(name string) bool {
 	sqTags := strings.Split(t.Value, "|")
 	for _, tag := range sqTags {
-		if tag == "ignore" {
+		if tag == name {
 			return true
 		}
 	}
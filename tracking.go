@@ -0,0 +1,141 @@
+package sq
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// 组合 sq.Tracker 后, 通过 QueryStruct QuerySlice 等方法查询到的 Model 或通过 sq.Track(ptr) 标记的 Model 会保存字段的快照
+// SaveModel 对比快照只更新发生变化的字段, 避免忘记在 []sq.Update 中增加字段
+// 	type User struct {
+// 		ID IDUser `db:"id"`
+// 		Name string `db:"name"`
+// 		sq.Tracker
+// 	}
+type Tracker struct {
+	snapshot map[Column]interface{}
+}
+func (t *Tracker) tracker() *Tracker {
+	return t
+}
+// 组合了 sq.Tracker 的 Model
+type Trackable interface {
+	Model
+	tracker() *Tracker
+}
+// 保存 ptr 当前字段的快照, 用于 SaveModel 对比
+func Track(ptr Trackable) {
+	snapshot := map[Column]interface{}{}
+	for _, field := range trackedFields(reflect.ValueOf(ptr).Elem(), true) {
+		snapshot[field.column] = snapshotValue(field.value)
+	}
+	ptr.tracker().snapshot = snapshot
+}
+
+// 只更新与快照不同的字段和 updated_at 等更新时间字段, 没有字段发生变化时不执行 SQL
+// 更新成功后会重新保存快照
+func (db *Database) SaveModel(ctx context.Context, ptr Trackable, checkSQL ...string) (result sql.Result, err error) {
+	return coreSaveModel(ctx, db, ptr, checkSQL...)
+}
+func (tx *Transaction) SaveModel(ctx context.Context, ptr Trackable, checkSQL ...string) (result sql.Result, err error) {
+	return coreSaveModel(ctx, tx, ptr, checkSQL...)
+}
+func coreSaveModel(ctx context.Context, storager Storager, ptr Trackable, checkSQL ...string) (result sql.Result, err error) {
+	snapshot := ptr.tracker().snapshot
+	if snapshot == nil {
+		return nil, errors.New("goclub/sql: SaveModel(ctx, ptr) " + reflect.TypeOf(ptr).String() + " is not tracked, query it by QueryStruct or call sq.Track(ptr) first")
+	}
+	fields := trackedFields(reflect.ValueOf(ptr).Elem(), true)
+	var updateData []Update
+	for _, field := range fields {
+		if !reflect.DeepEqual(snapshot[field.column], field.value.Interface()) {
+			updateData = append(updateData, Set(field.column, field.value.Interface()))
+		}
+	}
+	if len(updateData) == 0 {
+		return driver.RowsAffected(0), nil
+	}
+	for _, field := range trackedFields(reflect.ValueOf(ptr).Elem(), false) {
+		if !field.isUpdateTime || field.value.Type() != reflect.TypeOf(time.Time{}) {
+			continue
+		}
+		now := time.Now()
+		if strings.HasPrefix(field.structField.Name, "GMT") {
+			now = now.In(time.UTC)
+		}
+		field.value.Set(reflect.ValueOf(now))
+		// 顶层的更新时间字段由 coreUpdateModel 处理
+		if !field.topLevel {
+			updateData = append(updateData, Set(field.column, now))
+		}
+	}
+	result, err = coreUpdateModel(ctx, storager, ptr, updateData, nil, checkSQL...) ; if err != nil {
+		return
+	}
+	Track(ptr)
+	return
+}
+
+type trackedField struct {
+	column Column
+	value reflect.Value
+	structField reflect.StructField
+	topLevel bool
+	isUpdateTime bool
+}
+// 需要对比的字段, 主键 版本号 更新时间字段不需要对比
+func trackedFields(rValue reflect.Value, onlyCompared bool) (fields []trackedField) {
+	walkTrackedFields(rValue, true, func(field trackedField) {
+		if onlyCompared {
+			tag := Tag{field.structField.Tag.Get("sq")}
+			if field.column == "id" || tag.IsPrimaryKey() || tag.IsVersion() || tag.IsIgnore() || field.isUpdateTime {
+				return
+			}
+		}
+		fields = append(fields, field)
+	})
+	return
+}
+func walkTrackedFields(rValue reflect.Value, topLevel bool, handle func(field trackedField)) {
+	rType := rValue.Type()
+	for i:=0;i<rType.NumField();i++ {
+		structField := rType.Field(i)
+		column, hasDBTag := structField.Tag.Lookup("db")
+		if !hasDBTag {
+			if structField.Anonymous && structField.Type.Kind() == reflect.Struct {
+				walkTrackedFields(rValue.Field(i), false, handle)
+			}
+			continue
+		}
+		if column == "" || column == "-" {
+			continue
+		}
+		isUpdateTime := false
+		for _, timeField := range updateTimeField {
+			if structField.Name == timeField {
+				isUpdateTime = true
+			}
+		}
+		handle(trackedField{
+			column: Column(column),
+			value: rValue.Field(i),
+			structField: structField,
+			topLevel: topLevel,
+			isUpdateTime: isUpdateTime,
+		})
+	}
+}
+// []byte 需要复制, 避免修改底层数组后快照也发生变化
+func snapshotValue(value reflect.Value) interface{} {
+	if value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Uint8 && !value.IsNil() {
+		copied := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		reflect.Copy(copied, value)
+		return copied.Interface()
+	}
+	return value.Interface()
+}
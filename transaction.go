@@ -4,20 +4,66 @@ import (
 	"context"
 	"database/sql"
 	"github.com/jmoiron/sqlx"
+	"strconv"
 )
 
 type Transaction struct {
 	Core *sqlx.Tx
 	sqlChecker SQLChecker
+	dialect Dialect
+	maxAllowedPacket int
+	interceptors []Interceptor
+	logger Logger
+	slowQuery *slowQuery
+	// 用于在日志中区分不同的事务
+	id string
+	// 同一个事务中的所有 savepoint 共享计数器, 保证 savepoint 名称不重复
+	savepointCount *int
+	afterCommit []func(ctx context.Context)
+	afterRollback []func(ctx context.Context, err error)
+	txPanicAsError bool
 }
 func (tx *Transaction) getCore() (core StoragerCore) {
 	return tx.Core
 }
+// 事务中的查询都在主库执行
+func (tx *Transaction) getReadCore(ctx context.Context) (core StoragerCore) {
+	return tx.Core
+}
 func (tx *Transaction) getSQLChecker() (sqlChecker SQLChecker) {
 	return tx.sqlChecker
 }
-func newTx(tx *sqlx.Tx, sqlChecker SQLChecker) *Transaction {
-	return &Transaction{tx, sqlChecker}
+func (tx *Transaction) getDialect() (dialect Dialect) {
+	return tx.dialect
+}
+func (tx *Transaction) getMaxAllowedPacket() int {
+	return tx.maxAllowedPacket
+}
+func (tx *Transaction) getInterceptors() []Interceptor {
+	return tx.interceptors
+}
+func (tx *Transaction) getLogger() Logger {
+	return tx.logger
+}
+func (tx *Transaction) getSlowQuery() *slowQuery {
+	return tx.slowQuery
+}
+func (tx *Transaction) getTxID() string {
+	return tx.id
+}
+func newTx(coreTx *sqlx.Tx, db *Database) *Transaction {
+	return &Transaction{
+		Core: coreTx,
+		sqlChecker: db.sqlChecker,
+		dialect: db.dialect,
+		maxAllowedPacket: db.maxAllowedPacket,
+		interceptors: db.interceptors,
+		logger: db.logger,
+		slowQuery: db.slowQuery,
+		id: UUID(),
+		savepointCount: new(int),
+		txPanicAsError: db.txPanicAsError,
+	}
 }
 
 type TxResult struct {
@@ -59,20 +105,86 @@ func (db *Database) TransactionOpts(ctx context.Context, handle func (tx *Transa
 	coreTx, err := db.Core.BeginTxx(ctx, opts) ; if err != nil {
 		return
 	}
-	tx := newTx(coreTx, db.sqlChecker)
-	txResult := handle(tx)
+	tx := newTx(coreTx, db)
+	txResult, panicErr := tx.runHandle(handle)
+	if panicErr != nil {
+		// 回滚失败时仍然需要处理 panic, 所以忽略回滚的错误
+		_ = tx.Core.Rollback()
+		tx.runAfterRollback(ctx, panicErr)
+		if !tx.txPanicAsError {
+			panic(panicErr.Value)
+		}
+		return true, panicErr
+	}
+	// context 取消时 database/sql 已经自动回滚了事务, 不能再提交
+	if txResult.isCommit && ctx.Err() != nil {
+		txResult = tx.RollbackWithError(ctx.Err())
+	}
 	if txResult.isCommit {
 		err = tx.Core.Commit() ; if err != nil {
+			tx.runAfterRollback(ctx, err)
 			return
 		}
+		tx.runAfterCommit(ctx)
 		return
 	} else {
-		err = tx.Core.Rollback() ; if err != nil {
+		err = tx.Core.Rollback()
+		if err == sql.ErrTxDone && ctx.Err() != nil {
+			err = nil
+		}
+		if err != nil {
+			tx.runAfterRollback(ctx, err)
 			return true, err
 		}
+		tx.runAfterRollback(ctx, txResult.withError)
 		if txResult.withError != nil {
 			return true, txResult.withError
 		}
 		return true, nil
 	}
-}
\ No newline at end of file
+}
+// 通过 SAVEPOINT 实现嵌套事务, handle 返回 tx.Commit() 时 RELEASE SAVEPOINT, 否则 ROLLBACK TO SAVEPOINT
+// 嵌套事务回滚不会影响外层事务, 外层事务回滚时嵌套事务中的操作也会被回滚
+// 	func CreateOrder(ctx context.Context, tx *sq.Transaction) error {
+// 		_, err := tx.Transaction(ctx, func(tx *sq.Transaction) sq.TxResult {...})
+// 		return err
+// 	}
+func (tx *Transaction) Transaction(ctx context.Context, handle func (tx *Transaction) TxResult) (isRollback bool, err error) {
+	*tx.savepointCount++
+	savepoint := "sp_" + strconv.Itoa(*tx.savepointCount)
+	_, err = execContext(ctx, tx, "SAVEPOINT " + savepoint, nil) ; if err != nil {
+		return
+	}
+	// 嵌套事务使用独立的回调队列
+	savepointTx := *tx
+	savepointTx.afterCommit = nil
+	savepointTx.afterRollback = nil
+	txResult, panicErr := savepointTx.runHandle(handle)
+	if panicErr != nil {
+		_, _ = execContext(ctx, tx, "ROLLBACK TO SAVEPOINT " + savepoint, nil)
+		savepointTx.runAfterRollback(ctx, panicErr)
+		if !tx.txPanicAsError {
+			// 交给外层事务回滚
+			panic(panicErr)
+		}
+		return true, panicErr
+	}
+	if txResult.isCommit {
+		_, err = execContext(ctx, tx, "RELEASE SAVEPOINT " + savepoint, nil) ; if err != nil {
+			savepointTx.runAfterRollback(ctx, err)
+			return
+		}
+		tx.mergeCallbacks(&savepointTx)
+		return
+	} else {
+		_, err = execContext(ctx, tx, "ROLLBACK TO SAVEPOINT " + savepoint, nil) ; if err != nil {
+			savepointTx.runAfterRollback(ctx, err)
+			return true, err
+		}
+		savepointTx.runAfterRollback(ctx, txResult.withError)
+		if txResult.withError != nil {
+			return true, txResult.withError
+		}
+		return true, nil
+	}
+}
@@ -0,0 +1,67 @@
+package sq
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+)
+
+// 注册在事务提交成功后执行的函数, 按注册顺序执行, 常用于发布事件和清除缓存
+// 在嵌套事务(savepoint)中注册时, 只有外层事务最终提交成功才会执行
+// 	tx.AfterCommit(func(ctx context.Context) { cache.Delete(key) })
+func (tx *Transaction) AfterCommit(handle func(ctx context.Context)) {
+	tx.afterCommit = append(tx.afterCommit, handle)
+}
+// 注册在事务回滚后执行的函数, 按注册顺序执行
+// err 为 RollbackWithError 传入的错误或提交失败的错误, 使用 tx.Rollback() 时为 nil
+// 在嵌套事务(savepoint)中注册时, 嵌套事务回滚或外层事务回滚都会执行
+func (tx *Transaction) AfterRollback(handle func(ctx context.Context, err error)) {
+	tx.afterRollback = append(tx.afterRollback, handle)
+}
+
+// AfterCommit AfterRollback 注册的函数 panic 时会被 recover 并通过 Logger 记录, 未配置 Logger 时使用 log.Print
+type TxCallbackPanic struct {
+	Value interface{}
+	Stack []byte
+}
+func (p *TxCallbackPanic) Error() string {
+	return fmt.Sprintf("goclub/sql: transaction callback panic: %v\n%s", p.Value, p.Stack)
+}
+
+func (tx *Transaction) runAfterCommit(ctx context.Context) {
+	for _, handle := range tx.afterCommit {
+		handle := handle
+		tx.runCallback(ctx, func() { handle(ctx) })
+	}
+}
+func (tx *Transaction) runAfterRollback(ctx context.Context, err error) {
+	for _, handle := range tx.afterRollback {
+		handle := handle
+		tx.runCallback(ctx, func() { handle(ctx, err) })
+	}
+}
+// 事务已经结束, 回调 panic 不应影响事务的返回值, 也不应导致后续回调不执行
+func (tx *Transaction) runCallback(ctx context.Context, callback func()) {
+	defer func() {
+		r := recover() ; if r == nil {
+			return
+		}
+		panicErr := &TxCallbackPanic{Value: r, Stack: debug.Stack()}
+		if tx.logger == nil {
+			log.Print(panicErr.Error())
+			return
+		}
+		tx.logger.Log(ctx, LogEvent{
+			Rows: -1,
+			Err: panicErr,
+			TxID: tx.id,
+		})
+	}()
+	callback()
+}
+// 嵌套事务提交后回调交给外层事务, 由外层事务的结果决定执行哪些回调
+func (tx *Transaction) mergeCallbacks(savepointTx *Transaction) {
+	tx.afterCommit = append(tx.afterCommit, savepointTx.afterCommit...)
+	tx.afterRollback = append(tx.afterRollback, savepointTx.afterRollback...)
+}
@@ -0,0 +1,36 @@
+package sq
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// handle panic 时事务会先回滚, 然后重新 panic
+// 通过 db.SetTxPanicAsError(true) 配置后不再重新 panic, 而是返回 *TxPanicError
+type TxPanicError struct {
+	Value interface{}
+	Stack []byte
+}
+func (p *TxPanicError) Error() string {
+	return fmt.Sprintf("goclub/sql: transaction panic: %v\n%s", p.Value, p.Stack)
+}
+// 配置 handle panic 时的处理方式, 默认回滚后重新 panic, asError 为 true 时回滚后返回 *TxPanicError
+func (db *Database) SetTxPanicAsError(asError bool) {
+	db.txPanicAsError = asError
+}
+// 执行 handle 并 recover, 保证 panic 时事务能够回滚
+func (tx *Transaction) runHandle(handle func (tx *Transaction) TxResult) (txResult TxResult, panicErr *TxPanicError) {
+	defer func() {
+		r := recover() ; if r == nil {
+			return
+		}
+		// 嵌套事务(savepoint)重新 panic 的 *TxPanicError 保留了最初的调用栈
+		if nested, ok := r.(*TxPanicError); ok {
+			panicErr = nested
+			return
+		}
+		panicErr = &TxPanicError{Value: r, Stack: debug.Stack()}
+	}()
+	txResult = handle(tx)
+	return
+}
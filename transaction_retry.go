@@ -0,0 +1,61 @@
+package sq
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+	"time"
+)
+
+// 事务重试策略, 零值使用默认配置
+type RetryPolicy struct {
+	// 最多执行次数(包括第一次), 默认 3
+	MaxAttempts int
+	// 第 attempt 次执行失败后等待的时间, 默认从 10ms 开始指数增长到 1s 并随机抖动
+	Backoff func(attempt int) time.Duration
+	// 判断错误是否需要重试, 默认只重试死锁和锁等待超时
+	Retryable func(err error) bool
+}
+func (policy RetryPolicy) coalesce() RetryPolicy {
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = 3
+	}
+	if policy.Backoff == nil {
+		policy.Backoff = defaultRetryBackoff
+	}
+	if policy.Retryable == nil {
+		policy.Retryable = retryableTxError
+	}
+	return policy
+}
+func defaultRetryBackoff(attempt int) time.Duration {
+	backoff := 10 * time.Millisecond << uint(attempt-1)
+	if backoff <= 0 || backoff > time.Second {
+		backoff = time.Second
+	}
+	// 随机抖动, 避免发生死锁的多个事务同时重试再次死锁
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+func (db *Database) TransactionRetry(ctx context.Context, handle func (tx *Transaction) TxResult, opts *sql.TxOptions, policy RetryPolicy) (isRollback bool, attempts int, err error) {
+	policy = policy.coalesce()
+	for {
+		attempts++
+		isRollback, err = db.TransactionOpts(ctx, handle, opts)
+		if err == nil || attempts >= policy.MaxAttempts || !policy.Retryable(err) {
+			return
+		}
+		timer := time.NewTimer(policy.Backoff(attempts))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return isRollback, attempts, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// 死锁和锁等待超时时事务已经(或应当)回滚, 重新执行整个事务通常可以成功
+func retryableTxError(err error) bool {
+	return IsDeadlock(err) || IsLockWaitTimeout(err)
+}
@@ -17,9 +17,15 @@ type CreatedAtUpdatedAt struct {
 	CreatedAt time.Time `db:"created_at"`
 	UpdatedAt time.Time `db:"updated_at"`
 }
+// 只填充零值, 不会覆盖手动设置的创建时间和更新时间
 func (v *CreatedAtUpdatedAt) ColumnBeforeCreate() {
-	v.CreatedAt = time.Now()
-	v.UpdatedAt = time.Now()
+	now := time.Now()
+	if v.CreatedAt.IsZero() {
+		v.CreatedAt = now
+	}
+	if v.UpdatedAt.IsZero() {
+		v.UpdatedAt = now
+	}
 }
 func (v *CreatedAtUpdatedAt) ColumnBeforeUpdate() {
 	v.UpdatedAt = time.Now()
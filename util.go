@@ -46,9 +46,9 @@ func columnsToStrings (columns []Column) (strings []string) {
 	}
 	return
 }
-func columnsToStringsWithAS (columns []Column) (strings []string) {
+func columnsToStringsWithAS (columns []Column, dialect Dialect) (strings []string) {
 	for _, column := range columns {
-		strings = append(strings, column.wrapFieldWithAS())
+		strings = append(strings, column.wrapFieldWithAS(dialect))
 	}
 	return
 }
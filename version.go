@@ -0,0 +1,54 @@
+package sq
+
+import (
+	"errors"
+	"reflect"
+)
+
+// 组合 sq.Version 或给整数字段增加 `sq:"version"` 标签即可让 UpdateModel 使用乐观锁
+// UpdateModel 会增加 SET `version` = `version` + 1 和 WHERE `version` = ?, 没有更新任何行时返回 ErrStaleObject
+// 	type User struct {
+// 		ID IDUser `db:"id"`
+// 		sq.Version
+// 	}
+type Version struct {
+	Version uint64 `db:"version" sq:"version"`
+}
+var ErrStaleObject = errors.New("goclub/sql: UpdateModel affected 0 rows, the version has been changed or the row has been deleted")
+
+// 查找 `sq:"version"` 字段, 包括组合的结构体中的字段
+func versionField(rValue reflect.Value) (column Column, fieldValue reflect.Value, has bool) {
+	rType := rValue.Type()
+	for i:=0;i<rType.NumField();i++ {
+		fieldType := rType.Field(i)
+		dbTag, hasDBTag := fieldType.Tag.Lookup("db")
+		if !hasDBTag {
+			if fieldType.Anonymous && fieldType.Type.Kind() == reflect.Struct {
+				column, fieldValue, has = versionField(rValue.Field(i)) ; if has {
+					return
+				}
+			}
+			continue
+		}
+		if !(Tag{fieldType.Tag.Get("sq")}.IsVersion()) {
+			continue
+		}
+		switch fieldType.Type.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			panic(errors.New("goclub/sql: `sq:\"version\"` field must be int or uint, can not be " + fieldType.Type.String()))
+		}
+		return Column(dbTag), rValue.Field(i), true
+	}
+	return
+}
+// 更新成功后将内存中的版本号加一
+func increaseVersion(fieldValue reflect.Value) {
+	switch fieldValue.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		fieldValue.SetInt(fieldValue.Int() + 1)
+	default:
+		fieldValue.SetUint(fieldValue.Uint() + 1)
+	}
+}